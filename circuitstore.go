@@ -0,0 +1,273 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	_ CircuitBreakerStore = &circuitBreakerStore{}
+	_ Locker              = &circuitBreakerStore{}
+)
+
+var ErrCircuitOpen = errors.New("Session storage circuit breaker is open")
+
+// The state of a circuit breaker
+type CircuitState int
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+// Define default circuit breaker store options
+var defaultCircuitBreakerStoreOptions = circuitBreakerStoreOptions{
+	failureThreshold: 5,
+	openTimeout:      time.Second * 30,
+	callTimeout:      time.Second * 2,
+}
+
+type circuitBreakerStoreOptions struct {
+	failureThreshold int
+	openTimeout      time.Duration
+	callTimeout      time.Duration
+	fallback         ManagerStore
+}
+
+// A session storage guarded by a circuit breaker
+type CircuitBreakerStore interface {
+	ManagerStore
+	// Get the state of the circuit
+	State() CircuitState
+}
+
+type CircuitBreakerStoreOption func(*circuitBreakerStoreOptions)
+
+// Set the number of consecutive failures after which the circuit opens
+func SetCircuitBreakerFailureThreshold(threshold int) CircuitBreakerStoreOption {
+	return func(o *circuitBreakerStoreOptions) {
+		o.failureThreshold = threshold
+	}
+}
+
+// Set how long the circuit stays open before a call is let through to probe the store
+func SetCircuitBreakerOpenTimeout(openTimeout time.Duration) CircuitBreakerStoreOption {
+	return func(o *circuitBreakerStoreOptions) {
+		o.openTimeout = openTimeout
+	}
+}
+
+// Set the timeout of each call to the store (0 only uses the deadline of the context)
+func SetCircuitBreakerCallTimeout(callTimeout time.Duration) CircuitBreakerStoreOption {
+	return func(o *circuitBreakerStoreOptions) {
+		o.callTimeout = callTimeout
+	}
+}
+
+// Set the store used while the store fails or the circuit is open
+func SetCircuitBreakerFallback(fallback ManagerStore) CircuitBreakerStoreOption {
+	return func(o *circuitBreakerStoreOptions) {
+		o.fallback = fallback
+	}
+}
+
+// Create a new session storage that stops calling store after consecutive
+// failures, until a probe call succeeds. Failed and rejected calls return
+// the error, or use the fallback store if it is set
+func NewCircuitBreakerStore(store ManagerStore, opt ...CircuitBreakerStoreOption) CircuitBreakerStore {
+	opts := defaultCircuitBreakerStoreOptions
+	for _, o := range opt {
+		o(&opts)
+	}
+
+	return &circuitBreakerStore{
+		opts:  &opts,
+		store: store,
+	}
+}
+
+type circuitBreakerStore struct {
+	opts     *circuitBreakerStoreOptions
+	store    ManagerStore
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+}
+
+func (s *circuitBreakerStore) State() CircuitState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// Report whether a call may go through, a single probe call is
+// let through once the circuit has been open for the open timeout
+func (s *circuitBreakerStore) allow(t time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch s.state {
+	case CircuitOpen:
+		if t.Sub(s.openedAt) < s.opts.openTimeout {
+			return false
+		}
+		s.state = CircuitHalfOpen
+		return true
+	case CircuitHalfOpen:
+		return false
+	}
+	return true
+}
+
+// Record the result of an allowed call, a call cancelled by
+// the caller is not counted but ends a probe
+func (s *circuitBreakerStore) record(err error, cancelled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case cancelled:
+		if s.state == CircuitHalfOpen {
+			s.state = CircuitOpen
+		}
+	case err == nil:
+		s.state = CircuitClosed
+		s.failures = 0
+	case s.state == CircuitHalfOpen:
+		s.state = CircuitOpen
+		s.openedAt = now()
+	default:
+		s.failures++
+		if s.failures >= s.opts.failureThreshold {
+			s.state = CircuitOpen
+			s.openedAt = now()
+			s.failures = 0
+		}
+	}
+}
+
+// Call fn through the circuit breaker with the call timeout
+func (s *circuitBreakerStore) call(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !s.allow(now()) {
+		return ErrCircuitOpen
+	}
+
+	cctx := ctx
+	if s.opts.callTimeout > 0 {
+		var cancel context.CancelFunc
+		cctx, cancel = context.WithTimeout(ctx, s.opts.callTimeout)
+		defer cancel()
+	}
+
+	err := fn(cctx)
+	s.record(err, ctx.Err() != nil)
+	return err
+}
+
+// Get the store values of a call returning a session
+func (s *circuitBreakerStore) open(ctx context.Context, sid string, expired int64, fn func(ctx context.Context) (Store, error)) (Store, error) {
+	var values map[string]interface{}
+	err := s.call(ctx, func(ctx context.Context) error {
+		store, err := fn(ctx)
+		if err != nil || isNewStore(store) {
+			return err
+		}
+
+		v, ok := storeValues(store)
+		if !ok {
+			return ErrUnsupportedStore
+		}
+		values = v
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newStore(ctx, s, sid, expired, values), nil
+}
+
+func (s *circuitBreakerStore) save(ctx context.Context, sid string, values map[string]interface{}, expired int64) error {
+	err := s.call(ctx, func(ctx context.Context) error {
+		return saveValues(ctx, s.store, sid, values, expired)
+	})
+	if err != nil && s.opts.fallback != nil {
+		return saveValues(ctx, s.opts.fallback, sid, values, expired)
+	}
+	return err
+}
+
+func (s *circuitBreakerStore) Check(ctx context.Context, sid string) (bool, error) {
+	var exists bool
+	err := s.call(ctx, func(ctx context.Context) error {
+		var err error
+		exists, err = s.store.Check(ctx, sid)
+		return err
+	})
+	if err != nil && s.opts.fallback != nil {
+		return s.opts.fallback.Check(ctx, sid)
+	}
+	return exists, err
+}
+
+func (s *circuitBreakerStore) Create(ctx context.Context, sid string, expired int64) (Store, error) {
+	return newStore(ctx, s, sid, expired, nil), nil
+}
+
+func (s *circuitBreakerStore) Update(ctx context.Context, sid string, expired int64) (Store, error) {
+	store, err := s.open(ctx, sid, expired, func(ctx context.Context) (Store, error) {
+		return s.store.Update(ctx, sid, expired)
+	})
+	if err != nil && s.opts.fallback != nil {
+		return s.opts.fallback.Update(ctx, sid, expired)
+	}
+	return store, err
+}
+
+func (s *circuitBreakerStore) Delete(ctx context.Context, sid string) error {
+	err := s.call(ctx, func(ctx context.Context) error {
+		return s.store.Delete(ctx, sid)
+	})
+	if s.opts.fallback != nil {
+		if ferr := s.opts.fallback.Delete(ctx, sid); err == nil {
+			err = ferr
+		}
+	}
+	return err
+}
+
+func (s *circuitBreakerStore) Refresh(ctx context.Context, oldsid, sid string, expired int64) (Store, error) {
+	store, err := s.open(ctx, sid, expired, func(ctx context.Context) (Store, error) {
+		return s.store.Refresh(ctx, oldsid, sid, expired)
+	})
+	if err != nil && s.opts.fallback != nil {
+		return s.opts.fallback.Refresh(ctx, oldsid, sid, expired)
+	}
+	return store, err
+}
+
+// The session locks are held in the primary store
+func (s *circuitBreakerStore) Lock(ctx context.Context, sid string, ttl time.Duration) (string, error) {
+	return wrappedLocker(s.store).Lock(ctx, sid, ttl)
+}
+
+func (s *circuitBreakerStore) Renew(ctx context.Context, sid, token string, ttl time.Duration) error {
+	return wrappedLocker(s.store).Renew(ctx, sid, token, ttl)
+}
+
+func (s *circuitBreakerStore) Unlock(ctx context.Context, sid, token string) error {
+	return wrappedLocker(s.store).Unlock(ctx, sid, token)
+}
+
+func (s *circuitBreakerStore) Close() error {
+	err := s.store.Close()
+	if s.opts.fallback != nil {
+		if ferr := s.opts.fallback.Close(); err == nil {
+			err = ferr
+		}
+	}
+	return err
+}
@@ -0,0 +1,141 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// A ManagerStore blocking the checks until the context is done
+type blockingManagerStore struct {
+	ManagerStore
+}
+
+func (s *blockingManagerStore) Check(ctx context.Context, sid string) (bool, error) {
+	<-ctx.Done()
+	return false, ctx.Err()
+}
+
+func TestCircuitBreakerStore(t *testing.T) {
+	Convey("Test circuit breaker store", t, func() {
+		mstore := NewCircuitBreakerStore(NewMemoryStore())
+		defer mstore.Close()
+		testManagerStore(mstore)
+	})
+
+	Convey("Test circuit breaker store opens and probes the circuit", t, func() {
+		primary := &failingManagerStore{ManagerStore: NewMemoryStore()}
+		mstore := NewCircuitBreakerStore(primary,
+			SetCircuitBreakerFailureThreshold(2),
+			SetCircuitBreakerOpenTimeout(time.Millisecond*100),
+		)
+		defer mstore.Close()
+
+		ctx := context.Background()
+		primary.setFail(true)
+		for i := 0; i < 2; i++ {
+			_, err := mstore.Check(ctx, "a")
+			So(err, ShouldEqual, errTestStore)
+		}
+		So(mstore.State(), ShouldEqual, CircuitOpen)
+
+		_, err := mstore.Check(ctx, "a")
+		So(err, ShouldEqual, ErrCircuitOpen)
+		So(atomic.LoadInt32(&primary.calls), ShouldEqual, 2)
+
+		// a failed probe opens the circuit again
+		time.Sleep(time.Millisecond * 150)
+		_, err = mstore.Check(ctx, "a")
+		So(err, ShouldEqual, errTestStore)
+		So(mstore.State(), ShouldEqual, CircuitOpen)
+		_, err = mstore.Check(ctx, "a")
+		So(err, ShouldEqual, ErrCircuitOpen)
+
+		primary.setFail(false)
+		time.Sleep(time.Millisecond * 150)
+		_, err = mstore.Check(ctx, "a")
+		So(err, ShouldBeNil)
+		So(mstore.State(), ShouldEqual, CircuitClosed)
+	})
+
+	Convey("Test circuit breaker store uses the fallback store", t, func() {
+		primary := &failingManagerStore{ManagerStore: NewMemoryStore()}
+		fallback := NewMemoryStore()
+		mstore := NewCircuitBreakerStore(primary,
+			SetCircuitBreakerFailureThreshold(1),
+			SetCircuitBreakerFallback(fallback),
+		)
+		defer mstore.Close()
+
+		ctx := context.Background()
+		primary.setFail(true)
+		store, err := mstore.Create(ctx, "a", 10)
+		So(err, ShouldBeNil)
+		store.Set("foo", "bar")
+		So(store.Save(), ShouldBeNil)
+		So(mstore.State(), ShouldEqual, CircuitOpen)
+
+		exists, err := mstore.Check(ctx, "a")
+		So(err, ShouldBeNil)
+		So(exists, ShouldBeTrue)
+		store, err = mstore.Update(ctx, "a", 10)
+		So(err, ShouldBeNil)
+		foo, _ := store.Get("foo")
+		So(foo, ShouldEqual, "bar")
+
+		exists, err = fallback.Check(ctx, "a")
+		So(err, ShouldBeNil)
+		So(exists, ShouldBeTrue)
+
+		// the deletion fails until the store is available
+		So(mstore.Delete(ctx, "a"), ShouldEqual, ErrCircuitOpen)
+		exists, err = fallback.Check(ctx, "a")
+		So(err, ShouldBeNil)
+		So(exists, ShouldBeFalse)
+	})
+
+	Convey("Test circuit breaker store deletes from both stores", t, func() {
+		primary := NewMemoryStore()
+		fallback := &failingManagerStore{ManagerStore: NewMemoryStore()}
+		mstore := NewCircuitBreakerStore(primary, SetCircuitBreakerFallback(fallback))
+		defer mstore.Close()
+
+		ctx := context.Background()
+		saveMemorySession(mstore, "a", map[string]interface{}{"foo": "bar"})
+		fallback.setFail(true)
+		So(mstore.Delete(ctx, "a"), ShouldEqual, errTestStore)
+		exists, err := primary.Check(ctx, "a")
+		So(err, ShouldBeNil)
+		So(exists, ShouldBeFalse)
+
+		fallback.setFail(false)
+		So(mstore.Delete(ctx, "a"), ShouldBeNil)
+	})
+
+	Convey("Test circuit breaker store call timeout", t, func() {
+		mstore := NewCircuitBreakerStore(&blockingManagerStore{ManagerStore: NewMemoryStore()},
+			SetCircuitBreakerFailureThreshold(1),
+			SetCircuitBreakerCallTimeout(time.Millisecond*50),
+		)
+		defer mstore.Close()
+
+		_, err := mstore.Check(context.Background(), "a")
+		So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+		So(mstore.State(), ShouldEqual, CircuitOpen)
+
+		// calls cancelled by the caller are not counted
+		mstore = NewCircuitBreakerStore(&blockingManagerStore{ManagerStore: NewMemoryStore()},
+			SetCircuitBreakerFailureThreshold(1),
+		)
+		defer mstore.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+		defer cancel()
+		_, err = mstore.Check(ctx, "a")
+		So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+		So(mstore.State(), ShouldEqual, CircuitClosed)
+	})
+}
@@ -0,0 +1,506 @@
+package session
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sync"
+	"time"
+)
+
+var (
+	_ Codec = gobCodec{}
+	_ Codec = jsonCodec{}
+	_ Codec = binaryCodec{}
+
+	ErrInvalidCodecData = errors.New("Invalid encoded session data")
+)
+
+// Serialize the session values for out-of-process storage
+type Codec interface {
+	// Encode the session values
+	Marshal(values map[string]interface{}) ([]byte, error)
+	// Decode the session values
+	Unmarshal(data []byte) (map[string]interface{}, error)
+}
+
+var (
+	// Encode the values with encoding/gob, custom types must be registered
+	GobCodec Codec = gobCodec{}
+	// Encode the values as a JSON object, each value is tagged with its
+	// registered type name so that it is decoded to the same Go type,
+	// values of unregistered types are decoded as plain JSON values
+	JSONCodec Codec = jsonCodec{}
+	// Encode the values in a compact binary format, values of
+	// unregistered types are rejected
+	BinaryCodec Codec = binaryCodec{}
+
+	defaultCodec = GobCodec
+)
+
+// The registry of types whose values round-trip through the codecs
+var typeRegistry = struct {
+	sync.RWMutex
+	byName map[string]reflect.Type
+	byType map[reflect.Type]string
+}{
+	byName: make(map[string]reflect.Type),
+	byType: make(map[reflect.Type]string),
+}
+
+func init() {
+	for _, v := range []interface{}{
+		false, "", []byte(nil),
+		int(0), int8(0), int16(0), int32(0), int64(0),
+		uint(0), uint8(0), uint16(0), uint32(0), uint64(0),
+		float32(0), float64(0),
+		time.Time{}, time.Duration(0),
+		[]string(nil), []int(nil), []int64(nil), []interface{}(nil),
+		map[string]string(nil), map[string]interface{}(nil),
+	} {
+		RegisterType(v)
+	}
+}
+
+// return the name of the type, qualified by the package path for named types
+func typeName(t reflect.Type) string {
+	if t.Name() != "" && t.PkgPath() != "" {
+		return t.PkgPath() + "." + t.Name()
+	}
+	return t.String()
+}
+
+// Register the concrete type of value, so that session values of the type
+// keep their type when they are decoded
+func RegisterType(value interface{}) {
+	t := reflect.TypeOf(value)
+	name := typeName(t)
+
+	typeRegistry.Lock()
+	defer typeRegistry.Unlock()
+	if _, ok := typeRegistry.byType[t]; ok {
+		return
+	}
+	typeRegistry.byName[name] = t
+	typeRegistry.byType[t] = name
+	gob.Register(value)
+}
+
+func registeredType(name string) (reflect.Type, bool) {
+	typeRegistry.RLock()
+	t, ok := typeRegistry.byName[name]
+	typeRegistry.RUnlock()
+	return t, ok
+}
+
+func registeredName(t reflect.Type) (string, bool) {
+	typeRegistry.RLock()
+	name, ok := typeRegistry.byType[t]
+	typeRegistry.RUnlock()
+	return name, ok
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(values map[string]interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(values); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	if len(data) == 0 {
+		return values, nil
+	}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+type jsonCodec struct{}
+
+// A JSON encoded value and the name of its registered type
+type jsonValue struct {
+	Type  string          `json:"t,omitempty"`
+	Value json.RawMessage `json:"v"`
+}
+
+func (jsonCodec) Marshal(values map[string]interface{}) ([]byte, error) {
+	items := make(map[string]jsonValue, len(values))
+	for key, value := range values {
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+
+		item := jsonValue{Value: raw}
+		if value != nil {
+			item.Type, _ = registeredName(reflect.TypeOf(value))
+		}
+		items[key] = item
+	}
+	return json.Marshal(items)
+}
+
+func (jsonCodec) Unmarshal(data []byte) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	if len(data) == 0 {
+		return values, nil
+	}
+
+	var items map[string]jsonValue
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, err
+	}
+
+	for key, item := range items {
+		t, ok := registeredType(item.Type)
+		if !ok {
+			var v interface{}
+			if err := json.Unmarshal(item.Value, &v); err != nil {
+				return nil, err
+			}
+			values[key] = v
+			continue
+		}
+
+		v := reflect.New(t)
+		if err := json.Unmarshal(item.Value, v.Interface()); err != nil {
+			return nil, err
+		}
+		values[key] = v.Elem().Interface()
+	}
+	return values, nil
+}
+
+// The type tags of the binary codec
+const (
+	binaryNil byte = iota
+	binaryBool
+	binaryInt
+	binaryInt8
+	binaryInt16
+	binaryInt32
+	binaryInt64
+	binaryUint
+	binaryUint8
+	binaryUint16
+	binaryUint32
+	binaryUint64
+	binaryFloat32
+	binaryFloat64
+	binaryString
+	binaryBytes
+	binaryTime
+	binaryDuration
+	binaryStrings
+	binaryRegistered
+)
+
+type binaryCodec struct{}
+
+type binaryWriter struct {
+	bytes.Buffer
+	scratch [binary.MaxVarintLen64]byte
+}
+
+func (w *binaryWriter) uvarint(v uint64) {
+	w.Write(w.scratch[:binary.PutUvarint(w.scratch[:], v)])
+}
+
+func (w *binaryWriter) varint(v int64) {
+	w.Write(w.scratch[:binary.PutVarint(w.scratch[:], v)])
+}
+
+func (w *binaryWriter) bytes(b []byte) {
+	w.uvarint(uint64(len(b)))
+	w.Write(b)
+}
+
+func (w *binaryWriter) string(s string) {
+	w.uvarint(uint64(len(s)))
+	w.WriteString(s)
+}
+
+func (w *binaryWriter) value(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		w.WriteByte(binaryNil)
+	case bool:
+		w.WriteByte(binaryBool)
+		if v {
+			w.WriteByte(1)
+		} else {
+			w.WriteByte(0)
+		}
+	case int:
+		w.WriteByte(binaryInt)
+		w.varint(int64(v))
+	case int8:
+		w.WriteByte(binaryInt8)
+		w.varint(int64(v))
+	case int16:
+		w.WriteByte(binaryInt16)
+		w.varint(int64(v))
+	case int32:
+		w.WriteByte(binaryInt32)
+		w.varint(int64(v))
+	case int64:
+		w.WriteByte(binaryInt64)
+		w.varint(v)
+	case uint:
+		w.WriteByte(binaryUint)
+		w.uvarint(uint64(v))
+	case uint8:
+		w.WriteByte(binaryUint8)
+		w.uvarint(uint64(v))
+	case uint16:
+		w.WriteByte(binaryUint16)
+		w.uvarint(uint64(v))
+	case uint32:
+		w.WriteByte(binaryUint32)
+		w.uvarint(uint64(v))
+	case uint64:
+		w.WriteByte(binaryUint64)
+		w.uvarint(v)
+	case float32:
+		w.WriteByte(binaryFloat32)
+		binary.BigEndian.PutUint32(w.scratch[:4], math.Float32bits(v))
+		w.Write(w.scratch[:4])
+	case float64:
+		w.WriteByte(binaryFloat64)
+		binary.BigEndian.PutUint64(w.scratch[:8], math.Float64bits(v))
+		w.Write(w.scratch[:8])
+	case string:
+		w.WriteByte(binaryString)
+		w.string(v)
+	case []byte:
+		w.WriteByte(binaryBytes)
+		w.bytes(v)
+	case time.Time:
+		b, err := v.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		w.WriteByte(binaryTime)
+		w.bytes(b)
+	case time.Duration:
+		w.WriteByte(binaryDuration)
+		w.varint(int64(v))
+	case []string:
+		w.WriteByte(binaryStrings)
+		w.uvarint(uint64(len(v)))
+		for _, s := range v {
+			w.string(s)
+		}
+	default:
+		name, ok := registeredName(reflect.TypeOf(value))
+		if !ok {
+			return fmt.Errorf("session: type %T is not registered", value)
+		}
+
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).EncodeValue(reflect.ValueOf(value)); err != nil {
+			return err
+		}
+		w.WriteByte(binaryRegistered)
+		w.string(name)
+		w.bytes(buf.Bytes())
+	}
+	return nil
+}
+
+type binaryReader struct {
+	*bytes.Reader
+}
+
+func (r binaryReader) uvarint() (uint64, error) {
+	return binary.ReadUvarint(r)
+}
+
+func (r binaryReader) varint() (int64, error) {
+	return binary.ReadVarint(r)
+}
+
+func (r binaryReader) bytes() ([]byte, error) {
+	n, err := r.uvarint()
+	if err != nil {
+		return nil, err
+	} else if n > uint64(r.Len()) {
+		return nil, ErrInvalidCodecData
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (r binaryReader) fixed(n int) ([]byte, error) {
+	if r.Len() < n {
+		return nil, ErrInvalidCodecData
+	}
+	b := make([]byte, n)
+	_, err := io.ReadFull(r, b)
+	return b, err
+}
+
+func (r binaryReader) value() (interface{}, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tag {
+	case binaryNil:
+		return nil, nil
+	case binaryBool:
+		b, err := r.ReadByte()
+		return b == 1, err
+	case binaryInt, binaryInt8, binaryInt16, binaryInt32, binaryInt64, binaryDuration:
+		v, err := r.varint()
+		if err != nil {
+			return nil, err
+		}
+		switch tag {
+		case binaryInt:
+			return int(v), nil
+		case binaryInt8:
+			return int8(v), nil
+		case binaryInt16:
+			return int16(v), nil
+		case binaryInt32:
+			return int32(v), nil
+		case binaryDuration:
+			return time.Duration(v), nil
+		}
+		return v, nil
+	case binaryUint, binaryUint8, binaryUint16, binaryUint32, binaryUint64:
+		v, err := r.uvarint()
+		if err != nil {
+			return nil, err
+		}
+		switch tag {
+		case binaryUint:
+			return uint(v), nil
+		case binaryUint8:
+			return uint8(v), nil
+		case binaryUint16:
+			return uint16(v), nil
+		case binaryUint32:
+			return uint32(v), nil
+		}
+		return v, nil
+	case binaryFloat32:
+		b, err := r.fixed(4)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), nil
+	case binaryFloat64:
+		b, err := r.fixed(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case binaryString:
+		b, err := r.bytes()
+		return string(b), err
+	case binaryBytes:
+		return r.bytes()
+	case binaryTime:
+		b, err := r.bytes()
+		if err != nil {
+			return nil, err
+		}
+		var t time.Time
+		err = t.UnmarshalBinary(b)
+		return t, err
+	case binaryStrings:
+		n, err := r.uvarint()
+		if err != nil {
+			return nil, err
+		} else if n > uint64(r.Len()) {
+			return nil, ErrInvalidCodecData
+		}
+		ss := make([]string, n)
+		for i := range ss {
+			b, err := r.bytes()
+			if err != nil {
+				return nil, err
+			}
+			ss[i] = string(b)
+		}
+		return ss, nil
+	case binaryRegistered:
+		name, err := r.bytes()
+		if err != nil {
+			return nil, err
+		}
+		t, ok := registeredType(string(name))
+		if !ok {
+			return nil, fmt.Errorf("session: type %s is not registered", name)
+		}
+		b, err := r.bytes()
+		if err != nil {
+			return nil, err
+		}
+		v := reflect.New(t)
+		if err := gob.NewDecoder(bytes.NewReader(b)).DecodeValue(v); err != nil {
+			return nil, err
+		}
+		return v.Elem().Interface(), nil
+	}
+	return nil, ErrInvalidCodecData
+}
+
+func (binaryCodec) Marshal(values map[string]interface{}) ([]byte, error) {
+	var w binaryWriter
+	w.uvarint(uint64(len(values)))
+	for key, value := range values {
+		w.string(key)
+		if err := w.value(value); err != nil {
+			return nil, err
+		}
+	}
+	return w.Bytes(), nil
+}
+
+func (binaryCodec) Unmarshal(data []byte) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	if len(data) == 0 {
+		return values, nil
+	}
+
+	r := binaryReader{bytes.NewReader(data)}
+	n, err := r.uvarint()
+	if err != nil {
+		return nil, err
+	} else if n > uint64(r.Len()) {
+		return nil, ErrInvalidCodecData
+	}
+
+	for i := uint64(0); i < n; i++ {
+		key, err := r.bytes()
+		if err != nil {
+			return nil, err
+		}
+		value, err := r.value()
+		if err != nil {
+			return nil, err
+		}
+		values[string(key)] = value
+	}
+	return values, nil
+}
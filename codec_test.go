@@ -0,0 +1,118 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type testCodecUser struct {
+	ID   int64
+	Name string
+}
+
+func init() {
+	RegisterType(testCodecUser{})
+}
+
+func testCodec(codec Codec) {
+	values := map[string]interface{}{
+		"string":   "bar",
+		"int":      1,
+		"int64":    int64(1 << 40),
+		"uint8":    uint8(8),
+		"float64":  1.5,
+		"float32":  float32(2.5),
+		"bool":     true,
+		"bytes":    []byte("bytes"),
+		"time":     time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC),
+		"duration": time.Minute,
+		"strings":  []string{"a", "b"},
+		"user":     testCodecUser{ID: 1, Name: "foo"},
+	}
+
+	data, err := codec.Marshal(values)
+	So(err, ShouldBeNil)
+
+	decoded, err := codec.Unmarshal(data)
+	So(err, ShouldBeNil)
+	So(len(decoded), ShouldEqual, len(values))
+	for key, value := range values {
+		So(decoded[key], ShouldResemble, value)
+	}
+
+	empty, err := codec.Unmarshal(nil)
+	So(err, ShouldBeNil)
+	So(empty, ShouldBeEmpty)
+}
+
+func TestCodecs(t *testing.T) {
+	Convey("Test gob codec", t, func() {
+		testCodec(GobCodec)
+	})
+
+	Convey("Test json codec", t, func() {
+		testCodec(JSONCodec)
+
+		type unregistered struct{ Foo string }
+		data, err := JSONCodec.Marshal(map[string]interface{}{"foo": unregistered{Foo: "bar"}})
+		So(err, ShouldBeNil)
+		values, err := JSONCodec.Unmarshal(data)
+		So(err, ShouldBeNil)
+		So(values["foo"], ShouldResemble, map[string]interface{}{"Foo": "bar"})
+	})
+
+	Convey("Test binary codec", t, func() {
+		testCodec(BinaryCodec)
+
+		type unregistered struct{ Foo string }
+		_, err := BinaryCodec.Marshal(map[string]interface{}{"foo": unregistered{Foo: "bar"}})
+		So(err, ShouldNotBeNil)
+
+		_, err = BinaryCodec.Unmarshal([]byte{0xff, 0xff, 0xff})
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestManagerCodec(t *testing.T) {
+	dir := t.TempDir()
+	mstore, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mstore.Close()
+
+	manager := NewManager(SetStore(mstore), SetCodec(JSONCodec))
+
+	Convey("Test manager codec is used by the store", t, func() {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		store, err := manager.Start(context.Background(), w, r)
+		So(err, ShouldBeNil)
+
+		store.Set("user", testCodecUser{ID: 1, Name: "foo"})
+		So(store.Save(), ShouldBeNil)
+
+		entries, err := os.ReadDir(dir)
+		So(err, ShouldBeNil)
+		So(len(entries), ShouldEqual, 1)
+		buf, err := os.ReadFile(dir + "/" + entries[0].Name())
+		So(err, ShouldBeNil)
+		So(json.Valid(buf[8:]), ShouldBeTrue)
+
+		r = httptest.NewRequest("GET", "/", nil)
+		for _, cookie := range w.Result().Cookies() {
+			r.AddCookie(cookie)
+		}
+		store, err = manager.Start(context.Background(), httptest.NewRecorder(), r)
+		So(err, ShouldBeNil)
+		user, ok := store.Get("user")
+		So(ok, ShouldBeTrue)
+		So(user, ShouldResemble, testCodecUser{ID: 1, Name: "foo"})
+	})
+}
@@ -7,8 +7,10 @@ import (
 
 // Define the keys in the context
 type (
-	ctxResKey struct{}
-	ctxReqKey struct{}
+	ctxResKey   struct{}
+	ctxReqKey   struct{}
+	ctxCodecKey struct{}
+	ctxSessKey  struct{}
 )
 
 // returns a new Context that carries value res.
@@ -32,3 +34,29 @@ func FromReqContext(ctx context.Context) (*http.Request, bool) {
 	req, ok := ctx.Value(ctxReqKey{}).(*http.Request)
 	return req, ok
 }
+
+// returns a new Context that carries value codec.
+func newCodecContext(ctx context.Context, codec Codec) context.Context {
+	return context.WithValue(ctx, ctxCodecKey{}, codec)
+}
+
+// FromCodecContext returns the Codec value stored in ctx, if any.
+func FromCodecContext(ctx context.Context) (Codec, bool) {
+	codec, ok := ctx.Value(ctxCodecKey{}).(Codec)
+	return codec, ok
+}
+
+// returns a new Context that carries the lazily started session of the request.
+func newSessionContext(ctx context.Context, sess *lazySession) context.Context {
+	return context.WithValue(ctx, ctxSessKey{}, sess)
+}
+
+// FromContext returns the session of the request, the session is started on
+// first use. ctx must be the context of a request served by Manager.Middleware.
+func FromContext(ctx context.Context) (Store, error) {
+	sess, ok := ctx.Value(ctxSessKey{}).(*lazySession)
+	if !ok {
+		return nil, ErrNoSessionInContext
+	}
+	return sess.start()
+}
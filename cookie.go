@@ -0,0 +1,118 @@
+package session
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The default maximum size of a cookie value before it is split into chunks
+const defaultCookieChunkSize = 4000
+
+// return the name of the i-th chunk of the cookie
+func cookieChunkName(name string, i int) string {
+	return name + "_" + strconv.Itoa(i)
+}
+
+// report whether the cookie name is a chunk (name_0, name_1, ...) of the named cookie
+func isCookieChunk(cookieName, name string) (int, bool) {
+	if !strings.HasPrefix(cookieName, name+"_") {
+		return 0, false
+	}
+	i, err := strconv.Atoi(cookieName[len(name)+1:])
+	if err != nil || i < 0 {
+		return 0, false
+	}
+	return i, true
+}
+
+// return a copy of the cookie that makes the browser delete it
+func expiredCookie(cookie *http.Cookie, name string) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Path:     cookie.Path,
+		Domain:   cookie.Domain,
+		HttpOnly: cookie.HttpOnly,
+		Secure:   cookie.Secure,
+		SameSite: cookie.SameSite,
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
+	}
+}
+
+// split the cookie value into chunks of at most chunkSize bytes,
+// a value that fits (or a chunkSize <= 0) is kept in a single cookie
+func splitCookie(cookie *http.Cookie, chunkSize int) []*http.Cookie {
+	if chunkSize <= 0 || len(cookie.Value) <= chunkSize {
+		return []*http.Cookie{cookie}
+	}
+
+	var cookies []*http.Cookie
+	for i, value := 0, cookie.Value; len(value) > 0; i++ {
+		n := chunkSize
+		if n > len(value) {
+			n = len(value)
+		}
+
+		chunk := *cookie
+		chunk.Name = cookieChunkName(cookie.Name, i)
+		chunk.Value = value[:n]
+		cookies = append(cookies, &chunk)
+		value = value[n:]
+	}
+	return cookies
+}
+
+// write the cookies produced by splitCookie and delete the cookies
+// (the unchunked cookie or surplus chunks) left over in the request
+// from a previous value of different size
+func setCookieChunks(w http.ResponseWriter, r *http.Request, cookie *http.Cookie, cookies []*http.Cookie) {
+	chunked := len(cookies) > 1 || cookies[0].Name != cookie.Name
+
+	if r != nil {
+		for _, c := range r.Cookies() {
+			if c.Name == cookie.Name && chunked {
+				http.SetCookie(w, expiredCookie(cookie, c.Name))
+			} else if i, ok := isCookieChunk(c.Name, cookie.Name); ok && (!chunked || i >= len(cookies)) {
+				http.SetCookie(w, expiredCookie(cookie, c.Name))
+			}
+		}
+	}
+
+	for _, c := range cookies {
+		http.SetCookie(w, c)
+	}
+}
+
+// read the value of the named cookie, reassembling it from its chunks
+// if the unchunked cookie is not present
+func readCookie(r *http.Request, name string) string {
+	if cookie, err := r.Cookie(name); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+
+	var buf strings.Builder
+	for i := 0; ; i++ {
+		cookie, err := r.Cookie(cookieChunkName(name, i))
+		if err != nil {
+			break
+		}
+		buf.WriteString(cookie.Value)
+	}
+	return buf.String()
+}
+
+// delete the cookie and all of its chunks present in the request
+func deleteCookie(w http.ResponseWriter, r *http.Request, cookie *http.Cookie) {
+	http.SetCookie(w, expiredCookie(cookie, cookie.Name))
+
+	if r == nil {
+		return
+	}
+	for _, c := range r.Cookies() {
+		if _, ok := isCookieChunk(c.Name, cookie.Name); ok {
+			http.SetCookie(w, expiredCookie(cookie, c.Name))
+		}
+	}
+}
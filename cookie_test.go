@@ -0,0 +1,124 @@
+package session
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func responseCookies(w *httptest.ResponseRecorder) map[string]*http.Cookie {
+	cookies := make(map[string]*http.Cookie)
+	for _, cookie := range w.Result().Cookies() {
+		cookies[cookie.Name] = cookie
+	}
+	return cookies
+}
+
+func TestCookieChunks(t *testing.T) {
+	cookieName := "test_cookie_chunks"
+
+	Convey("Test session id cookie split into chunks", t, func() {
+		manager := NewManager(SetCookieName(cookieName), SetCookieChunkSize(20))
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		store, err := manager.Start(context.Background(), w, r)
+		So(err, ShouldBeNil)
+		store.Set("foo", "bar")
+		So(store.Save(), ShouldBeNil)
+
+		cookies := w.Result().Cookies()
+		So(len(cookies), ShouldBeGreaterThan, 1)
+		for i, cookie := range cookies {
+			So(cookie.Name, ShouldEqual, cookieChunkName(cookieName, i))
+			So(len(cookie.Value), ShouldBeLessThanOrEqualTo, 20)
+		}
+
+		r = httptest.NewRequest("GET", "/", nil)
+		for _, cookie := range cookies {
+			r.AddCookie(cookie)
+		}
+		vstore, err := manager.Start(context.Background(), httptest.NewRecorder(), r)
+		So(err, ShouldBeNil)
+		So(vstore.SessionID(), ShouldEqual, store.SessionID())
+
+		Convey("Stale chunks are deleted when the value shrinks", func() {
+			manager := NewManager(SetCookieName(cookieName), SetStore(manager.opts.store))
+			w := httptest.NewRecorder()
+			_, err := manager.Refresh(context.Background(), w, r)
+			So(err, ShouldBeNil)
+
+			res := responseCookies(w)
+			So(res[cookieName].MaxAge, ShouldBeGreaterThan, 0)
+			for i := range cookies {
+				So(res[cookieChunkName(cookieName, i)].MaxAge, ShouldBeLessThan, 0)
+			}
+		})
+
+		Convey("All chunks are deleted on destroy", func() {
+			w := httptest.NewRecorder()
+			So(manager.Destroy(context.Background(), w, r), ShouldBeNil)
+
+			res := responseCookies(w)
+			So(res[cookieName].MaxAge, ShouldBeLessThan, 0)
+			for i := range cookies {
+				So(res[cookieChunkName(cookieName, i)].MaxAge, ShouldBeLessThan, 0)
+			}
+		})
+	})
+}
+
+func TestCookieStoreChunks(t *testing.T) {
+	mstore, err := NewCookieStore([][]byte{testCookieKey},
+		SetCookieStoreName("test_cookie_store_chunks"),
+		SetCookieStoreMaxChunks(4),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager := NewManager(SetStore(mstore), SetSecure(false))
+
+	Convey("Test cookie store splits large payloads", t, func() {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		store, err := manager.Start(context.Background(), w, r)
+		So(err, ShouldBeNil)
+
+		large := strings.Repeat("x", maxCookieSize*2)
+		store.Set("foo", large)
+		So(store.Save(), ShouldBeNil)
+
+		res := responseCookies(w)
+		So(res, ShouldContainKey, "test_cookie_store_chunks_0")
+		So(res, ShouldContainKey, "test_cookie_store_chunks_2")
+		for _, cookie := range res {
+			So(len(cookie.String()), ShouldBeLessThanOrEqualTo, maxCookieSize)
+		}
+
+		r = httptest.NewRequest("GET", "/", nil)
+		for _, cookie := range w.Result().Cookies() {
+			r.AddCookie(cookie)
+		}
+		w = httptest.NewRecorder()
+		store, err = manager.Start(context.Background(), w, r)
+		So(err, ShouldBeNil)
+		foo, ok := store.Get("foo")
+		So(ok, ShouldBeTrue)
+		So(foo, ShouldEqual, large)
+
+		store.Set("foo", "bar")
+		So(store.Save(), ShouldBeNil)
+
+		res = responseCookies(w)
+		So(res["test_cookie_store_chunks"].MaxAge, ShouldBeGreaterThan, 0)
+		So(res["test_cookie_store_chunks_0"].MaxAge, ShouldBeLessThan, 0)
+		So(res["test_cookie_store_chunks_2"].MaxAge, ShouldBeLessThan, 0)
+
+		store.Set("foo", strings.Repeat("x", maxCookieSize*4))
+		So(store.Save(), ShouldEqual, ErrCookieTooLarge)
+	})
+}
@@ -0,0 +1,330 @@
+package session
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+var _ ManagerStore = &cookieStore{}
+
+var (
+	ErrCookieTooLarge     = errors.New("Session cookie exceeds the size limit")
+	ErrInvalidCookieValue = errors.New("Invalid session cookie value")
+	ErrNoCookieKeys       = errors.New("At least one cookie encryption key is required")
+	ErrNoResponseWriter   = errors.New("No http.ResponseWriter in the context")
+)
+
+// The maximum size of a cookie (name and value) accepted by browsers
+const maxCookieSize = 4096
+
+// Define default cookie store options
+var defaultCookieStoreOptions = cookieStoreOptions{
+	cookieName: "go_session_data",
+	path:       "/",
+	secure:     true,
+	sameSite:   http.SameSiteDefaultMode,
+	maxSize:    maxCookieSize,
+	maxChunks:  1,
+}
+
+type cookieStoreOptions struct {
+	cookieName string
+	path       string
+	domain     string
+	secure     bool
+	sameSite   http.SameSite
+	maxSize    int
+	maxChunks  int
+}
+
+type CookieStoreOption func(*cookieStoreOptions)
+
+// Set the name of the cookie holding the session data
+func SetCookieStoreName(cookieName string) CookieStoreOption {
+	return func(o *cookieStoreOptions) {
+		o.cookieName = cookieName
+	}
+}
+
+// Set the path of the session data cookie
+func SetCookieStorePath(path string) CookieStoreOption {
+	return func(o *cookieStoreOptions) {
+		o.path = path
+	}
+}
+
+// Set the domain of the session data cookie
+func SetCookieStoreDomain(domain string) CookieStoreOption {
+	return func(o *cookieStoreOptions) {
+		o.domain = domain
+	}
+}
+
+// Set the security of the session data cookie
+func SetCookieStoreSecure(secure bool) CookieStoreOption {
+	return func(o *cookieStoreOptions) {
+		o.secure = secure
+	}
+}
+
+// Set SameSite attribute of the session data cookie
+func SetCookieStoreSameSite(sameSite http.SameSite) CookieStoreOption {
+	return func(o *cookieStoreOptions) {
+		o.sameSite = sameSite
+	}
+}
+
+// Set the maximum size (in bytes) of the session data cookie
+func SetCookieStoreMaxSize(maxSize int) CookieStoreOption {
+	return func(o *cookieStoreOptions) {
+		o.maxSize = maxSize
+	}
+}
+
+// Set the maximum number of cookies the session data may be split across,
+// each cookie is limited by the maximum size (default 1, no splitting)
+func SetCookieStoreMaxChunks(maxChunks int) CookieStoreOption {
+	return func(o *cookieStoreOptions) {
+		o.maxChunks = maxChunks
+	}
+}
+
+// Create a new session storage (client-side cookie), the session values
+// are encrypted with AES-GCM and kept in the response cookie,
+// so no state is held on the server.
+//
+// Each key must be 16, 24 or 32 bytes long. The first key encrypts new
+// cookies, all keys are tried when decrypting, which allows keys to be rotated
+// by prepending the new key and dropping the old one later.
+func NewCookieStore(keys [][]byte, opt ...CookieStoreOption) (ManagerStore, error) {
+	opts := defaultCookieStoreOptions
+	for _, o := range opt {
+		o(&opts)
+	}
+
+	if len(keys) == 0 {
+		return nil, ErrNoCookieKeys
+	}
+
+	cstore := &cookieStore{opts: &opts}
+	for _, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		cstore.aeads = append(cstore.aeads, aead)
+	}
+	return cstore, nil
+}
+
+type cookieStore struct {
+	opts  *cookieStoreOptions
+	aeads []cipher.AEAD
+}
+
+// The decrypted content of the session data cookie
+type cookiePayload struct {
+	sid       string
+	expiredAt time.Time
+	values    map[string]interface{}
+}
+
+// encode and encrypt the payload, the plaintext is the expiration time
+// (unix nano), the length-prefixed session id and the encoded values
+func (s *cookieStore) seal(ctx context.Context, p *cookiePayload) (string, error) {
+	data, err := encodeValues(ctx, p.values)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext := make([]byte, 8, 8+binary.MaxVarintLen64+len(p.sid)+len(data))
+	binary.BigEndian.PutUint64(plaintext, uint64(p.expiredAt.UnixNano()))
+	var lenBuf [binary.MaxVarintLen64]byte
+	plaintext = append(plaintext, lenBuf[:binary.PutUvarint(lenBuf[:], uint64(len(p.sid)))]...)
+	plaintext = append(plaintext, p.sid...)
+	plaintext = append(plaintext, data...)
+
+	aead := s.aeads[0]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	ciphertext := aead.Seal(nonce, nonce, plaintext, []byte(s.opts.cookieName))
+	return base64.RawURLEncoding.EncodeToString(ciphertext), nil
+}
+
+func (s *cookieStore) open(ctx context.Context, value string) (*cookiePayload, error) {
+	ciphertext, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCookieValue
+	}
+
+	var plaintext []byte
+	for _, aead := range s.aeads {
+		if len(ciphertext) < aead.NonceSize() {
+			continue
+		}
+		nonce := ciphertext[:aead.NonceSize()]
+		plaintext, err = aead.Open(nil, nonce, ciphertext[aead.NonceSize():], []byte(s.opts.cookieName))
+		if err == nil {
+			break
+		}
+	}
+	if plaintext == nil || len(plaintext) < 8 {
+		return nil, ErrInvalidCookieValue
+	}
+
+	expiredAt := time.Unix(0, int64(binary.BigEndian.Uint64(plaintext[:8])))
+	n, l := binary.Uvarint(plaintext[8:])
+	if l <= 0 || uint64(len(plaintext)-8-l) < n {
+		return nil, ErrInvalidCookieValue
+	}
+	sid := string(plaintext[8+l : 8+l+int(n)])
+
+	values, err := decodeValues(ctx, plaintext[8+l+int(n):])
+	if err != nil {
+		return nil, err
+	}
+	return &cookiePayload{sid: sid, expiredAt: expiredAt, values: values}, nil
+}
+
+// read the payload of the session from the request cookie,
+// invalid or expired cookies are treated as nonexistent
+func (s *cookieStore) read(ctx context.Context, sid string) *cookiePayload {
+	r, ok := FromReqContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	value := readCookie(r, s.opts.cookieName)
+	if value == "" {
+		return nil
+	}
+
+	p, err := s.open(ctx, value)
+	if err != nil || p.sid != sid || !p.expiredAt.After(now()) {
+		return nil
+	}
+	return p
+}
+
+func (s *cookieStore) newCookie(value string, maxAge int) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     s.opts.cookieName,
+		Value:    value,
+		Path:     s.opts.path,
+		Domain:   s.opts.domain,
+		HttpOnly: true,
+		Secure:   s.opts.secure,
+		SameSite: s.opts.sameSite,
+		MaxAge:   maxAge,
+	}
+	if maxAge > 0 {
+		cookie.Expires = now().Add(time.Duration(maxAge) * time.Second)
+	} else if maxAge < 0 {
+		cookie.Expires = time.Unix(0, 0)
+	}
+	return cookie
+}
+
+func (s *cookieStore) write(ctx context.Context, sid string, values map[string]interface{}, expired int64) error {
+	w, ok := FromResContext(ctx)
+	if !ok {
+		return ErrNoResponseWriter
+	}
+
+	value, err := s.seal(ctx, &cookiePayload{
+		sid:       sid,
+		expiredAt: now().Add(time.Duration(expired) * time.Second),
+		values:    values,
+	})
+	if err != nil {
+		return err
+	}
+
+	cookie := s.newCookie(value, int(expired))
+	cookies := []*http.Cookie{cookie}
+	if len(cookie.String()) > s.opts.maxSize {
+		if s.opts.maxChunks <= 1 {
+			return ErrCookieTooLarge
+		}
+
+		// the size of the attributes of the last chunk bounds the chunk value size
+		chunk := *cookie
+		chunk.Name = cookieChunkName(cookie.Name, s.opts.maxChunks-1)
+		chunk.Value = ""
+		cookies = splitCookie(cookie, s.opts.maxSize-len(chunk.String()))
+		if len(cookies) > s.opts.maxChunks || len(cookies) == 1 {
+			return ErrCookieTooLarge
+		}
+	}
+
+	r, _ := FromReqContext(ctx)
+	setCookieChunks(w, r, cookie, cookies)
+	return nil
+}
+
+func (s *cookieStore) save(ctx context.Context, sid string, values map[string]interface{}, expired int64) error {
+	return s.write(ctx, sid, values, expired)
+}
+
+func (s *cookieStore) Check(ctx context.Context, sid string) (bool, error) {
+	return s.read(ctx, sid) != nil, nil
+}
+
+func (s *cookieStore) Create(ctx context.Context, sid string, expired int64) (Store, error) {
+	return newStore(ctx, s, sid, expired, nil), nil
+}
+
+// the cookie is written again so that the expiration time slides
+func (s *cookieStore) Update(ctx context.Context, sid string, expired int64) (Store, error) {
+	p := s.read(ctx, sid)
+	if p == nil {
+		return newStore(ctx, s, sid, expired, nil), nil
+	}
+
+	if err := s.write(ctx, sid, p.values, expired); err != nil {
+		return nil, err
+	}
+	return newStore(ctx, s, sid, expired, p.values), nil
+}
+
+func (s *cookieStore) Delete(ctx context.Context, _ string) error {
+	w, ok := FromResContext(ctx)
+	if !ok {
+		return ErrNoResponseWriter
+	}
+
+	r, _ := FromReqContext(ctx)
+	deleteCookie(w, r, s.newCookie("", -1))
+	return nil
+}
+
+func (s *cookieStore) Refresh(ctx context.Context, oldsid, sid string, expired int64) (Store, error) {
+	p := s.read(ctx, oldsid)
+	if p == nil {
+		return newStore(ctx, s, sid, expired, nil), nil
+	}
+
+	if err := s.write(ctx, sid, p.values, expired); err != nil {
+		return nil, err
+	}
+	return newStore(ctx, s, sid, expired, p.values), nil
+}
+
+func (s *cookieStore) Close() error {
+	return nil
+}
@@ -0,0 +1,180 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var (
+	testCookieKey    = []byte("0123456789abcdef0123456789abcdef")
+	testCookieOldKey = []byte("fedcba9876543210")
+)
+
+func newCookieStoreHandler(t *testing.T, manager *Manager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		store, err := manager.Start(r.Context(), w, r)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if r.URL.Query().Get("login") == "1" {
+			foo, ok := store.Get("foo")
+			fmt.Fprintf(w, "%v:%v", foo, ok)
+			return
+		}
+
+		store.Set("foo", "bar")
+		err = store.Save()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	})
+}
+
+func getWithCookies(url string, cookies []*http.Cookie) (string, *http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", nil, err
+	}
+	buf, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	return string(buf), res, err
+}
+
+func TestCookieStore(t *testing.T) {
+	mstore, err := NewCookieStore([][]byte{testCookieKey}, SetCookieStoreSecure(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manager := NewManager(SetCookieName("test_cookie_store"), SetStore(mstore))
+	ts := httptest.NewServer(newCookieStoreHandler(t, manager))
+	defer ts.Close()
+
+	Convey("Test cookie store keeps the session in the client", t, func() {
+		body, res, err := getWithCookies(ts.URL, nil)
+		So(err, ShouldBeNil)
+		So(body, ShouldEqual, "ok")
+		So(len(res.Cookies()), ShouldEqual, 2)
+
+		// a new manager and store, nothing is kept on the server
+		mstore, err := NewCookieStore([][]byte{testCookieKey}, SetCookieStoreSecure(false))
+		So(err, ShouldBeNil)
+		manager := NewManager(SetCookieName("test_cookie_store"), SetStore(mstore))
+		ts2 := httptest.NewServer(newCookieStoreHandler(t, manager))
+		defer ts2.Close()
+
+		body, _, err = getWithCookies(ts2.URL+"?login=1", res.Cookies())
+		So(err, ShouldBeNil)
+		So(body, ShouldEqual, "bar:true")
+	})
+}
+
+func TestCookieStoreKeyRotation(t *testing.T) {
+	oldStore, err := NewCookieStore([][]byte{testCookieOldKey}, SetCookieStoreSecure(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newStore, err := NewCookieStore([][]byte{testCookieKey, testCookieOldKey}, SetCookieStoreSecure(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherStore, err := NewCookieStore([][]byte{testCookieKey}, SetCookieStoreSecure(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(newCookieStoreHandler(t, NewManager(SetStore(oldStore))))
+	defer ts.Close()
+	ts2 := httptest.NewServer(newCookieStoreHandler(t, NewManager(SetStore(newStore))))
+	defer ts2.Close()
+	ts3 := httptest.NewServer(newCookieStoreHandler(t, NewManager(SetStore(otherStore))))
+	defer ts3.Close()
+
+	Convey("Test cookie store decrypts with rotated keys", t, func() {
+		_, res, err := getWithCookies(ts.URL, nil)
+		So(err, ShouldBeNil)
+
+		body, _, err := getWithCookies(ts2.URL+"?login=1", res.Cookies())
+		So(err, ShouldBeNil)
+		So(body, ShouldEqual, "bar:true")
+
+		body, _, err = getWithCookies(ts3.URL+"?login=1", res.Cookies())
+		So(err, ShouldBeNil)
+		So(body, ShouldEqual, "<nil>:false")
+	})
+}
+
+func TestCookieStoreTooLarge(t *testing.T) {
+	mstore, err := NewCookieStore([][]byte{testCookieKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("Test cookie store size limit", t, func() {
+		w := httptest.NewRecorder()
+		ctx := newResContext(context.Background(), w)
+
+		store, err := mstore.Create(ctx, "test_cookie_store_large", 10)
+		So(err, ShouldBeNil)
+
+		store.Set("foo", strings.Repeat("x", maxCookieSize))
+		So(store.Save(), ShouldEqual, ErrCookieTooLarge)
+		So(w.Header().Get("Set-Cookie"), ShouldBeEmpty)
+
+		store.Set("foo", "bar")
+		So(store.Save(), ShouldBeNil)
+		So(w.Header().Get("Set-Cookie"), ShouldNotBeEmpty)
+	})
+}
+
+func TestCookieStoreDestroy(t *testing.T) {
+	mstore, err := NewCookieStore([][]byte{testCookieKey}, SetCookieStoreSecure(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager := NewManager(SetStore(mstore))
+
+	Convey("Test cookie store destroy expires the data cookie", t, func() {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		store, err := manager.Start(context.Background(), w, r)
+		So(err, ShouldBeNil)
+		So(store.Save(), ShouldBeNil)
+
+		res := w.Result()
+		r = httptest.NewRequest("GET", "/", nil)
+		for _, cookie := range res.Cookies() {
+			r.AddCookie(cookie)
+		}
+
+		w = httptest.NewRecorder()
+		So(manager.Destroy(context.Background(), w, r), ShouldBeNil)
+
+		var expired int
+		for _, cookie := range w.Result().Cookies() {
+			if cookie.MaxAge < 0 {
+				expired++
+			}
+		}
+		So(expired, ShouldEqual, 2)
+	})
+}
@@ -37,3 +37,13 @@ func Destroy(ctx context.Context, w http.ResponseWriter, r *http.Request) error
 func Refresh(ctx context.Context, w http.ResponseWriter, r *http.Request) (Store, error) {
 	return manager().Refresh(ctx, w, r)
 }
+
+// Start a session and save the changes made by fn, retrying on conflict
+func Update(ctx context.Context, w http.ResponseWriter, r *http.Request, fn func(Store) error) error {
+	return manager().Update(ctx, w, r, fn)
+}
+
+// Wrap the handler to start the session lazily and save it automatically
+func Middleware(next http.Handler) http.Handler {
+	return manager().Middleware(next)
+}
@@ -0,0 +1,82 @@
+package session
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// A min-heap of the memory store items ordered by expiration time,
+// so that the gc only touches the sessions that are due
+type expiryQueue struct {
+	mu    sync.Mutex
+	items expiryHeap
+}
+
+// Add the item or move it to its new expiration time
+func (q *expiryQueue) schedule(item *dataItem, expiredAt time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	item.queueAt = expiredAt
+	if item.queueIndex >= 0 {
+		heap.Fix(&q.items, item.queueIndex)
+		return
+	}
+	heap.Push(&q.items, item)
+}
+
+func (q *expiryQueue) unschedule(item *dataItem) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if item.queueIndex >= 0 {
+		heap.Remove(&q.items, item.queueIndex)
+	}
+}
+
+// Remove and return the items that expire before t
+func (q *expiryQueue) due(t time.Time) []*dataItem {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var items []*dataItem
+	for len(q.items) > 0 && q.items[0].queueAt.Before(t) {
+		items = append(items, heap.Pop(&q.items).(*dataItem))
+	}
+	return items
+}
+
+func (q *expiryQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+type expiryHeap []*dataItem
+
+func (h expiryHeap) Len() int { return len(h) }
+
+func (h expiryHeap) Less(i, j int) bool { return h[i].queueAt.Before(h[j].queueAt) }
+
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].queueIndex = i
+	h[j].queueIndex = j
+}
+
+func (h *expiryHeap) Push(x interface{}) {
+	item := x.(*dataItem)
+	item.queueIndex = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	item.queueIndex = -1
+	*h = old[:n-1]
+	return item
+}
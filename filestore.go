@@ -0,0 +1,285 @@
+package session
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var _ ManagerStore = &fileStore{}
+
+const fileStoreExt = ".session"
+
+var ErrInvalidSessionFile = errors.New("Invalid session file")
+
+// Define default file store options
+var defaultFileStoreOptions = fileStoreOptions{
+	gcInterval: time.Minute,
+	fileMode:   0600,
+}
+
+type fileStoreOptions struct {
+	gcInterval time.Duration
+	fileMode   os.FileMode
+}
+
+type FileStoreOption func(*fileStoreOptions)
+
+// Set the interval at which expired session files are removed
+func SetFileStoreGCInterval(interval time.Duration) FileStoreOption {
+	return func(o *fileStoreOptions) {
+		o.gcInterval = interval
+	}
+}
+
+// Set the permission bits of the session files
+func SetFileStoreFileMode(mode os.FileMode) FileStoreOption {
+	return func(o *fileStoreOptions) {
+		o.fileMode = mode
+	}
+}
+
+// Create a new session storage (file system),
+// each session is persisted as a single file in the dir directory
+func NewFileStore(dir string, opt ...FileStoreOption) (ManagerStore, error) {
+	opts := defaultFileStoreOptions
+	for _, o := range opt {
+		o(&opts)
+	}
+	if opts.gcInterval <= 0 {
+		opts.gcInterval = defaultFileStoreOptions.gcInterval
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	fstore := &fileStore{
+		dir:  dir,
+		opts: &opts,
+		done: make(chan struct{}),
+	}
+
+	fstore.wg.Add(1)
+	go fstore.gc()
+	return fstore, nil
+}
+
+type fileStore struct {
+	dir       string
+	opts      *fileStoreOptions
+	done      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// The file content is the expiration time (unix nano, big endian)
+// followed by the encoded session values
+type fileItem struct {
+	expiredAt time.Time
+	data      []byte
+}
+
+func (s *fileStore) filename(sid string) string {
+	sum := sha256.Sum256([]byte(sid))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+fileStoreExt)
+}
+
+func (s *fileStore) readFile(name string) (*fileItem, error) {
+	buf, err := os.ReadFile(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	} else if len(buf) < 8 {
+		return nil, ErrInvalidSessionFile
+	}
+
+	return &fileItem{
+		expiredAt: time.Unix(0, int64(binary.BigEndian.Uint64(buf[:8]))),
+		data:      buf[8:],
+	}, nil
+}
+
+// read the session values, expired sessions are treated as nonexistent
+func (s *fileStore) read(ctx context.Context, sid string) (map[string]interface{}, error) {
+	item, err := s.readFile(s.filename(sid))
+	if err != nil || item == nil {
+		return nil, err
+	} else if !item.expiredAt.After(now()) {
+		return nil, nil
+	}
+	return decodeValues(ctx, item.data)
+}
+
+// write the session item to a temporary file and rename it into place,
+// so that readers never observe a partially written session
+func (s *fileStore) write(ctx context.Context, sid string, values map[string]interface{}, expired int64) error {
+	data, err := encodeValues(ctx, values)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, 8, 8+len(data))
+	expiredAt := now().Add(time.Duration(expired) * time.Second)
+	binary.BigEndian.PutUint64(buf, uint64(expiredAt.UnixNano()))
+	buf = append(buf, data...)
+
+	f, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := f.Name()
+
+	if _, err = f.Write(buf); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmpName, s.opts.fileMode)
+	}
+	if err == nil {
+		err = os.Rename(tmpName, s.filename(sid))
+	}
+	if err != nil {
+		_ = os.Remove(tmpName)
+	}
+	return err
+}
+
+// rewrite the expiration time of a session file in place, the values are
+// left untouched so that a concurrent save is not overwritten
+func (s *fileStore) touch(sid string, expired int64) error {
+	f, err := os.OpenFile(s.filename(sid), os.O_WRONLY, 0)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var buf [8]byte
+	expiredAt := now().Add(time.Duration(expired) * time.Second)
+	binary.BigEndian.PutUint64(buf[:], uint64(expiredAt.UnixNano()))
+	_, err = f.WriteAt(buf[:], 0)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (s *fileStore) remove(sid string) error {
+	err := os.Remove(s.filename(sid))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *fileStore) gc() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.opts.gcInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.removeExpired()
+		}
+	}
+}
+
+func (s *fileStore) removeExpired() {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), fileStoreExt) {
+			continue
+		}
+
+		name := filepath.Join(s.dir, entry.Name())
+		item, err := s.readFile(name)
+		if err == ErrInvalidSessionFile || (err == nil && item != nil && !item.expiredAt.After(now())) {
+			_ = os.Remove(name)
+		}
+	}
+}
+
+func (s *fileStore) save(ctx context.Context, sid string, values map[string]interface{}, expired int64) error {
+	return s.write(ctx, sid, values, expired)
+}
+
+func (s *fileStore) Check(ctx context.Context, sid string) (bool, error) {
+	values, err := s.read(ctx, sid)
+	if err != nil {
+		return false, err
+	}
+	return values != nil, nil
+}
+
+func (s *fileStore) Create(ctx context.Context, sid string, expired int64) (Store, error) {
+	return newStore(ctx, s, sid, expired, nil), nil
+}
+
+func (s *fileStore) Update(ctx context.Context, sid string, expired int64) (Store, error) {
+	values, err := s.read(ctx, sid)
+	if err != nil {
+		return nil, err
+	} else if values == nil {
+		return newStore(ctx, s, sid, expired, nil), nil
+	}
+
+	if err := s.touch(sid, expired); err != nil {
+		return nil, err
+	}
+	return newStore(ctx, s, sid, expired, values), nil
+}
+
+func (s *fileStore) Delete(_ context.Context, sid string) error {
+	return s.remove(sid)
+}
+
+func (s *fileStore) Refresh(ctx context.Context, oldsid, sid string, expired int64) (Store, error) {
+	if oldsid == sid {
+		return s.Update(ctx, sid, expired)
+	}
+
+	values, err := s.read(ctx, oldsid)
+	if err != nil {
+		return nil, err
+	} else if values == nil {
+		return newStore(ctx, s, sid, expired, nil), nil
+	}
+
+	if err := s.write(ctx, sid, values, expired); err != nil {
+		return nil, err
+	}
+	if err := s.remove(oldsid); err != nil {
+		return nil, err
+	}
+	return newStore(ctx, s, sid, expired, values), nil
+}
+
+func (s *fileStore) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.wg.Wait()
+	})
+	return nil
+}
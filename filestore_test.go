@@ -0,0 +1,145 @@
+package session
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFileStore(t *testing.T) {
+	mstore, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mstore.Close()
+
+	Convey("Test file storage operation", t, func() {
+		store, err := mstore.Create(context.Background(), "test_file_store", 10)
+		So(err, ShouldBeNil)
+		testStore(store)
+	})
+}
+
+func TestManagerFileStore(t *testing.T) {
+	mstore, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mstore.Close()
+
+	Convey("Test file-based storage management operations", t, func() {
+		testManagerStore(mstore)
+		testRefreshSameID(mstore)
+	})
+}
+
+func TestFileStoreWithExpired(t *testing.T) {
+	mstore, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mstore.Close()
+
+	Convey("Test file store expiration", t, func() {
+		testStoreWithExpired(mstore)
+	})
+}
+
+func TestFileStoreReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	Convey("Test file store persistence across instances", t, func() {
+		mstore, err := NewFileStore(dir)
+		So(err, ShouldBeNil)
+
+		store, err := mstore.Create(context.Background(), "test_file_store_reopen", 10)
+		So(err, ShouldBeNil)
+		store.Set("foo", "bar")
+		So(store.Save(), ShouldBeNil)
+		So(mstore.Close(), ShouldBeNil)
+
+		mstore, err = NewFileStore(dir)
+		So(err, ShouldBeNil)
+		defer mstore.Close()
+
+		exists, err := mstore.Check(context.Background(), "test_file_store_reopen")
+		So(err, ShouldBeNil)
+		So(exists, ShouldBeTrue)
+
+		store, err = mstore.Update(context.Background(), "test_file_store_reopen", 10)
+		So(err, ShouldBeNil)
+		foo, ok := store.Get("foo")
+		So(ok, ShouldBeTrue)
+		So(foo, ShouldEqual, "bar")
+	})
+}
+
+func TestFileStoreUpdateKeepsValues(t *testing.T) {
+	mstore, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mstore.Close()
+
+	Convey("Test file store update only extends the expiration", t, func() {
+		ctx := context.Background()
+		fstore := mstore.(*fileStore)
+		store, err := mstore.Create(ctx, "test_file_store_update", 1)
+		So(err, ShouldBeNil)
+		store.Set("foo", "bar")
+		So(store.Save(), ShouldBeNil)
+
+		before, err := fstore.readFile(fstore.filename("test_file_store_update"))
+		So(err, ShouldBeNil)
+
+		_, err = mstore.Update(ctx, "test_file_store_update", 100)
+		So(err, ShouldBeNil)
+
+		after, err := fstore.readFile(fstore.filename("test_file_store_update"))
+		So(err, ShouldBeNil)
+		So(after.data, ShouldResemble, before.data)
+		So(after.expiredAt.After(before.expiredAt.Add(time.Second*90)), ShouldBeTrue)
+
+		// a session deleted concurrently is not written again
+		So(fstore.touch("test_file_store_missing", 10), ShouldBeNil)
+		exists, err := mstore.Check(ctx, "test_file_store_missing")
+		So(err, ShouldBeNil)
+		So(exists, ShouldBeFalse)
+	})
+}
+
+func TestFileStoreGC(t *testing.T) {
+	dir := t.TempDir()
+	mstore, err := NewFileStore(dir, SetFileStoreGCInterval(time.Millisecond*100))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("Test file store removes expired files", t, func() {
+		store, err := mstore.Create(context.Background(), "test_file_store_gc", 1)
+		So(err, ShouldBeNil)
+		So(store.Save(), ShouldBeNil)
+
+		entries, err := os.ReadDir(dir)
+		So(err, ShouldBeNil)
+		So(len(entries), ShouldEqual, 1)
+
+		time.Sleep(time.Millisecond * 1500)
+
+		entries, err = os.ReadDir(dir)
+		So(err, ShouldBeNil)
+		So(len(entries), ShouldEqual, 0)
+
+		So(mstore.Close(), ShouldBeNil)
+		So(mstore.Close(), ShouldBeNil)
+	})
+
+	Convey("Test file store with a zero gc interval", t, func() {
+		mstore, err := NewFileStore(t.TempDir(), SetFileStoreGCInterval(0))
+		So(err, ShouldBeNil)
+		So(mstore.Close(), ShouldBeNil)
+	})
+}
@@ -0,0 +1,155 @@
+package session
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+)
+
+// The typed getters coerce the representations a value may take after a
+// round-trip through a codec (e.g. JSON turns an int64 into a float64),
+// ok is false if the value does not exist and a *TypeMismatchError is
+// returned if it cannot be coerced.
+
+// Get session value as string
+func GetString(store Store, key string) (string, bool, error) {
+	value, ok := store.Get(key)
+	if !ok {
+		return "", false, nil
+	}
+
+	switch v := value.(type) {
+	case string:
+		return v, true, nil
+	case []byte:
+		return string(v), true, nil
+	}
+	return "", true, &TypeMismatchError{Key: key, Value: value, Expected: "string"}
+}
+
+func toInt64(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint:
+		return int64(v), v <= math.MaxInt64
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint64:
+		return int64(v), v <= math.MaxInt64
+	case float32:
+		return toInt64(float64(v))
+	case float64:
+		if v != math.Trunc(v) || v < math.MinInt64 || v >= math.MaxInt64 {
+			return 0, false
+		}
+		return int64(v), true
+	case json.Number:
+		i, err := v.Int64()
+		return i, err == nil
+	}
+	return 0, false
+}
+
+// Get session value as int64, any integer type and integral floats are accepted
+func GetInt64(store Store, key string) (int64, bool, error) {
+	value, ok := store.Get(key)
+	if !ok {
+		return 0, false, nil
+	}
+
+	if v, ok := toInt64(value); ok {
+		return v, true, nil
+	}
+	return 0, true, &TypeMismatchError{Key: key, Value: value, Expected: "int64"}
+}
+
+// Get session value as bool
+func GetBool(store Store, key string) (bool, bool, error) {
+	value, ok := store.Get(key)
+	if !ok {
+		return false, false, nil
+	}
+
+	if v, ok := value.(bool); ok {
+		return v, true, nil
+	}
+	return false, true, &TypeMismatchError{Key: key, Value: value, Expected: "bool"}
+}
+
+// Get session value as time.Time, RFC 3339 strings are accepted
+func GetTime(store Store, key string) (time.Time, bool, error) {
+	value, ok := store.Get(key)
+	if !ok {
+		return time.Time{}, false, nil
+	}
+
+	switch v := value.(type) {
+	case time.Time:
+		return v, true, nil
+	case string:
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return t, true, nil
+		}
+	}
+	return time.Time{}, true, &TypeMismatchError{Key: key, Value: value, Expected: "time.Time"}
+}
+
+// Get session value as time.Duration, integers (nanoseconds) and
+// duration strings (e.g. "1m30s") are accepted
+func GetDuration(store Store, key string) (time.Duration, bool, error) {
+	value, ok := store.Get(key)
+	if !ok {
+		return 0, false, nil
+	}
+
+	switch v := value.(type) {
+	case time.Duration:
+		return v, true, nil
+	case string:
+		if d, err := time.ParseDuration(v); err == nil {
+			return d, true, nil
+		}
+	default:
+		if i, ok := toInt64(v); ok {
+			return time.Duration(i), true, nil
+		}
+	}
+	return 0, true, &TypeMismatchError{Key: key, Value: value, Expected: "time.Duration"}
+}
+
+// Get session value as []string, slices whose elements are all strings are accepted
+func GetStringSlice(store Store, key string) ([]string, bool, error) {
+	value, ok := store.Get(key)
+	if !ok {
+		return nil, false, nil
+	}
+
+	switch v := value.(type) {
+	case []string:
+		return v, true, nil
+	case []interface{}:
+		ss := make([]string, len(v))
+		for i, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, true, &TypeMismatchError{Key: key, Value: value, Expected: "[]string"}
+			}
+			ss[i] = s
+		}
+		return ss, true, nil
+	}
+	return nil, true, &TypeMismatchError{Key: key, Value: value, Expected: "[]string"}
+}
@@ -0,0 +1,112 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestGetters(t *testing.T) {
+	mstore := NewMemoryStore()
+	defer mstore.Close()
+
+	Convey("Test typed getters", t, func() {
+		store, err := mstore.Create(context.Background(), "test_getters", 10)
+		So(err, ShouldBeNil)
+
+		now := time.Date(2021, 1, 2, 3, 4, 5, 6, time.UTC)
+		store.Set("string", "bar")
+		store.Set("int64", int64(1<<40))
+		store.Set("bool", true)
+		store.Set("time", now)
+		store.Set("duration", time.Minute)
+		store.Set("strings", []string{"a", "b"})
+
+		s, ok, err := GetString(store, "string")
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeTrue)
+		So(s, ShouldEqual, "bar")
+
+		i, _, err := GetInt64(store, "int64")
+		So(err, ShouldBeNil)
+		So(i, ShouldEqual, 1<<40)
+
+		b, _, err := GetBool(store, "bool")
+		So(err, ShouldBeNil)
+		So(b, ShouldBeTrue)
+
+		tm, _, err := GetTime(store, "time")
+		So(err, ShouldBeNil)
+		So(tm, ShouldEqual, now)
+
+		d, _, err := GetDuration(store, "duration")
+		So(err, ShouldBeNil)
+		So(d, ShouldEqual, time.Minute)
+
+		ss, _, err := GetStringSlice(store, "strings")
+		So(err, ShouldBeNil)
+		So(ss, ShouldResemble, []string{"a", "b"})
+
+		_, ok, err = GetString(store, "missing")
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeFalse)
+
+		Convey("Values decoded from JSON are coerced", func() {
+			data, err := JSONCodec.Marshal(map[string]interface{}{
+				"int64":    float64(1 << 40),
+				"time":     now.Format(time.RFC3339Nano),
+				"duration": "1m0s",
+				"strings":  []interface{}{"a", "b"},
+			})
+			So(err, ShouldBeNil)
+			values, err := JSONCodec.Unmarshal(data)
+			So(err, ShouldBeNil)
+			for key, value := range values {
+				store.Set(key, value)
+			}
+
+			i, _, err := GetInt64(store, "int64")
+			So(err, ShouldBeNil)
+			So(i, ShouldEqual, 1<<40)
+
+			tm, _, err := GetTime(store, "time")
+			So(err, ShouldBeNil)
+			So(tm.Equal(now), ShouldBeTrue)
+
+			d, _, err := GetDuration(store, "duration")
+			So(err, ShouldBeNil)
+			So(d, ShouldEqual, time.Minute)
+
+			ss, _, err := GetStringSlice(store, "strings")
+			So(err, ShouldBeNil)
+			So(ss, ShouldResemble, []string{"a", "b"})
+		})
+
+		Convey("Mismatched values return ErrTypeMismatch", func() {
+			store.Set("float", 1.5)
+
+			_, ok, err := GetInt64(store, "float")
+			So(ok, ShouldBeTrue)
+			So(errors.Is(err, ErrTypeMismatch), ShouldBeTrue)
+
+			_, _, err = GetString(store, "int64")
+			So(errors.Is(err, ErrTypeMismatch), ShouldBeTrue)
+
+			_, _, err = GetBool(store, "string")
+			So(errors.Is(err, ErrTypeMismatch), ShouldBeTrue)
+
+			_, _, err = GetTime(store, "string")
+			So(errors.Is(err, ErrTypeMismatch), ShouldBeTrue)
+
+			_, _, err = GetDuration(store, "string")
+			So(errors.Is(err, ErrTypeMismatch), ShouldBeTrue)
+
+			store.Set("mixed", []interface{}{"a", 1})
+			_, _, err = GetStringSlice(store, "mixed")
+			So(errors.Is(err, ErrTypeMismatch), ShouldBeTrue)
+		})
+	})
+}
@@ -1,6 +1,6 @@
 module github.com/go-session/session/v3
 
-go 1.17
+go 1.18
 
 require (
 	github.com/bytedance/gopkg v0.0.0-20221122125632-68358b8ecec6
@@ -0,0 +1,54 @@
+package session
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// A consistent hash ring, each node is placed on the ring replicas times
+// (virtual nodes) to spread the keys evenly
+type hashRing struct {
+	nodes  []string
+	points []uint32
+	owners map[uint32]int
+}
+
+func newHashRing(nodes []string, replicas int) *hashRing {
+	if replicas <= 0 {
+		replicas = 1
+	}
+
+	r := &hashRing{
+		nodes:  nodes,
+		points: make([]uint32, 0, len(nodes)*replicas),
+		owners: make(map[uint32]int, len(nodes)*replicas),
+	}
+
+	for i, node := range nodes {
+		for j := 0; j < replicas; j++ {
+			point := crc32.ChecksumIEEE([]byte(node + "#" + strconv.Itoa(j)))
+			if _, ok := r.owners[point]; ok {
+				continue
+			}
+			r.owners[point] = i
+			r.points = append(r.points, point)
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// return the index of the node that owns the key, or -1 if the ring is empty
+func (r *hashRing) get(key string) int {
+	if len(r.points) == 0 {
+		return -1
+	}
+
+	h := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
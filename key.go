@@ -0,0 +1,90 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrTypeMismatch = errors.New("Session value type mismatch")
+
+// The error returned when a session value does not have the expected type,
+// it matches ErrTypeMismatch with errors.Is
+type TypeMismatchError struct {
+	Key      string
+	Value    interface{}
+	Expected string
+}
+
+func (e *TypeMismatchError) Error() string {
+	return fmt.Sprintf("session: value of key %q is %T, not %s", e.Key, e.Value, e.Expected)
+}
+
+func (e *TypeMismatchError) Unwrap() error {
+	return ErrTypeMismatch
+}
+
+// A typed session key, values are read and written through the Store
+// and checked against the type parameter
+//
+//	var userID = session.NewKey[int64]("user_id")
+//
+//	userID.Set(store, 1)
+//	id, ok, err := userID.Get(store)
+type Key[T any] struct {
+	name string
+}
+
+// Create a typed session key
+func NewKey[T any](name string) Key[T] {
+	return Key[T]{name: name}
+}
+
+// Get the key name
+func (k Key[T]) Name() string {
+	return k.name
+}
+
+func (k Key[T]) assert(value interface{}) (T, error) {
+	v, ok := value.(T)
+	if !ok {
+		var zero T
+		return zero, &TypeMismatchError{
+			Key:      k.name,
+			Value:    value,
+			Expected: fmt.Sprintf("%T", &zero)[1:],
+		}
+	}
+	return v, nil
+}
+
+// Get session value, ok is false if the value does not exist and
+// a *TypeMismatchError is returned if it is not of type T
+func (k Key[T]) Get(store Store) (T, bool, error) {
+	value, ok := store.Get(k.name)
+	if !ok {
+		var zero T
+		return zero, false, nil
+	}
+
+	v, err := k.assert(value)
+	return v, true, err
+}
+
+// Set session value, call save function to take effect
+func (k Key[T]) Set(store Store, value T) {
+	store.Set(k.name, value)
+}
+
+// Delete session value and return the deleted value,
+// call save function to take effect
+func (k Key[T]) Delete(store Store) (T, bool, error) {
+	value, ok := store.Get(k.name)
+	if !ok {
+		var zero T
+		return zero, false, nil
+	}
+
+	store.Delete(k.name)
+	v, err := k.assert(value)
+	return v, true, err
+}
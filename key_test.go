@@ -0,0 +1,55 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestKey(t *testing.T) {
+	mstore := NewMemoryStore()
+	defer mstore.Close()
+
+	Convey("Test typed session keys", t, func() {
+		store, err := mstore.Create(context.Background(), "test_key", 10)
+		So(err, ShouldBeNil)
+
+		userID := NewKey[int64]("user_id")
+		So(userID.Name(), ShouldEqual, "user_id")
+
+		id, ok, err := userID.Get(store)
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeFalse)
+		So(id, ShouldEqual, 0)
+
+		userID.Set(store, 42)
+		id, ok, err = userID.Get(store)
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeTrue)
+		So(id, ShouldEqual, 42)
+
+		store.Set("user_id", "42")
+		id, ok, err = userID.Get(store)
+		So(ok, ShouldBeTrue)
+		So(errors.Is(err, ErrTypeMismatch), ShouldBeTrue)
+		So(err.Error(), ShouldEqual, `session: value of key "user_id" is string, not int64`)
+		So(id, ShouldEqual, 0)
+
+		roles := NewKey[[]string]("roles")
+		roles.Set(store, []string{"admin"})
+		v, ok, err := roles.Delete(store)
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeTrue)
+		So(v, ShouldResemble, []string{"admin"})
+
+		_, ok = store.Get("roles")
+		So(ok, ShouldBeFalse)
+
+		var tmErr *TypeMismatchError
+		_, _, err = NewKey[error]("user_id").Get(store)
+		So(errors.As(err, &tmErr), ShouldBeTrue)
+		So(tmErr.Expected, ShouldEqual, "error")
+	})
+}
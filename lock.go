@@ -0,0 +1,221 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	_ Locker = &memoryLocker{}
+	_ Store  = &lockedStore{}
+)
+
+var (
+	ErrLockNotHeld      = errors.New("Session lock is not held")
+	ErrLockNotSupported = errors.New("Session storage does not implement Locker")
+)
+
+// Implemented by session storages that can serialize the requests sharing a session
+type Locker interface {
+	// Acquire the lock of a session for ttl and return the lock token,
+	// waits until the lock is released, expires or ctx is done
+	Lock(ctx context.Context, sid string, ttl time.Duration) (string, error)
+	// Extend the lock of a session held with token for ttl
+	Renew(ctx context.Context, sid, token string, ttl time.Duration) error
+	// Release the lock of a session held with token
+	Unlock(ctx context.Context, sid, token string) error
+}
+
+// Get the Locker of a session storage wrapped by another storage,
+// the locks of a storage that does not implement it fail with ErrLockNotSupported
+func wrappedLocker(store ManagerStore) Locker {
+	if locker, ok := store.(Locker); ok {
+		return locker
+	}
+	return unsupportedLocker{}
+}
+
+type unsupportedLocker struct{}
+
+func (unsupportedLocker) Lock(context.Context, string, time.Duration) (string, error) {
+	return "", ErrLockNotSupported
+}
+
+func (unsupportedLocker) Renew(context.Context, string, string, time.Duration) error {
+	return ErrLockNotSupported
+}
+
+func (unsupportedLocker) Unlock(context.Context, string, string) error {
+	return ErrLockNotSupported
+}
+
+// Define the predicate of the requests that hold the session lock
+type LockPredicateFunc func(*http.Request) bool
+
+type memoryLock struct {
+	token     string
+	expiredAt time.Time
+	released  chan struct{}
+}
+
+// An in-process Locker, locks expire if not renewed within their ttl
+type memoryLocker struct {
+	mu    sync.Mutex
+	locks map[string]*memoryLock
+}
+
+func newMemoryLocker() *memoryLocker {
+	return &memoryLocker{locks: make(map[string]*memoryLock)}
+}
+
+func (l *memoryLocker) Lock(ctx context.Context, sid string, ttl time.Duration) (string, error) {
+	for {
+		l.mu.Lock()
+		lock, ok := l.locks[sid]
+		if !ok || !lock.expiredAt.After(now()) {
+			if ok {
+				close(lock.released)
+			}
+
+			token := newUUID()
+			l.locks[sid] = &memoryLock{
+				token:     token,
+				expiredAt: now().Add(ttl),
+				released:  make(chan struct{}),
+			}
+			l.mu.Unlock()
+			return token, nil
+		}
+		released, wait := lock.released, lock.expiredAt.Sub(now())
+		l.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-released:
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return "", ctx.Err()
+		}
+		timer.Stop()
+	}
+}
+
+// get the lock held with token, l.mu must be held
+func (l *memoryLocker) held(sid, token string) (*memoryLock, bool) {
+	lock, ok := l.locks[sid]
+	if !ok || lock.token != token || !lock.expiredAt.After(now()) {
+		return nil, false
+	}
+	return lock, true
+}
+
+func (l *memoryLocker) Renew(_ context.Context, sid, token string, ttl time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lock, ok := l.held(sid, token)
+	if !ok {
+		return ErrLockNotHeld
+	}
+	lock.expiredAt = now().Add(ttl)
+	return nil
+}
+
+func (l *memoryLocker) Unlock(_ context.Context, sid, token string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lock, ok := l.held(sid, token)
+	if !ok {
+		return ErrLockNotHeld
+	}
+	delete(l.locks, sid)
+	close(lock.released)
+	return nil
+}
+
+// A session store holding the session lock until it is saved, the lease
+// is renewed in the background and released when the request context is done
+type lockedStore struct {
+	Store
+	locker Locker
+	token  string
+	done   chan struct{}
+	once   sync.Once
+	wg     sync.WaitGroup
+}
+
+func newLockedStore(ctx context.Context, store Store, locker Locker, token string, ttl time.Duration) *lockedStore {
+	s := &lockedStore{
+		Store:  store,
+		locker: locker,
+		token:  token,
+		done:   make(chan struct{}),
+	}
+
+	s.wg.Add(1)
+	go s.renew(ctx, ttl)
+	return s
+}
+
+func (s *lockedStore) renew(ctx context.Context, ttl time.Duration) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(ttl / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if s.locker.Renew(ctx, s.SessionID(), s.token, ttl) != nil {
+				return
+			}
+		case <-ctx.Done():
+			_ = s.unlock()
+			return
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Release the lock once, the renewal is stopped before
+func (s *lockedStore) unlock() error {
+	var err error
+	s.once.Do(func() {
+		close(s.done)
+		err = s.locker.Unlock(context.Background(), s.SessionID(), s.token)
+	})
+	return err
+}
+
+// Release the lock and wait for the renewal to stop
+func (s *lockedStore) release() {
+	_ = s.unlock()
+	s.wg.Wait()
+}
+
+// Save the session data and release the session lock
+func (s *lockedStore) Save() error {
+	err := s.Store.Save()
+	s.release()
+	return err
+}
+
+// Clear the session data and release the session lock
+func (s *lockedStore) Flush() error {
+	err := s.Store.Flush()
+	s.release()
+	return err
+}
+
+// Release the session lock held by a session store returned by Start, if any
+func releaseLock(store Store) {
+	if ls, ok := store.(*lockedStore); ok {
+		ls.release()
+	}
+}
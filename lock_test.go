@@ -0,0 +1,234 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMemoryLocker(t *testing.T) {
+	Convey("Test memory locker", t, func() {
+		locker := newMemoryLocker()
+		ctx := context.Background()
+		sid := "test_memory_locker"
+
+		token, err := locker.Lock(ctx, sid, time.Second)
+		So(err, ShouldBeNil)
+
+		tctx, cancel := context.WithTimeout(ctx, time.Millisecond*50)
+		_, err = locker.Lock(tctx, sid, time.Second)
+		cancel()
+		So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+
+		So(locker.Renew(ctx, sid, token, time.Second), ShouldBeNil)
+		So(locker.Renew(ctx, sid, "invalid", time.Second), ShouldEqual, ErrLockNotHeld)
+		So(locker.Unlock(ctx, sid, "invalid"), ShouldEqual, ErrLockNotHeld)
+
+		acquired := make(chan string)
+		go func() {
+			token, _ := locker.Lock(ctx, sid, time.Second)
+			acquired <- token
+		}()
+		So(locker.Unlock(ctx, sid, token), ShouldBeNil)
+
+		token = <-acquired
+		So(token, ShouldNotBeEmpty)
+		So(locker.Unlock(ctx, sid, token), ShouldBeNil)
+		So(locker.Unlock(ctx, sid, token), ShouldEqual, ErrLockNotHeld)
+	})
+
+	Convey("Test memory locker lease expires", t, func() {
+		locker := newMemoryLocker()
+		ctx := context.Background()
+		sid := "test_memory_locker_expired"
+
+		token, err := locker.Lock(ctx, sid, time.Millisecond*50)
+		So(err, ShouldBeNil)
+
+		next, err := locker.Lock(ctx, sid, time.Second)
+		So(err, ShouldBeNil)
+		So(next, ShouldNotEqual, token)
+		So(locker.Renew(ctx, sid, token, time.Second), ShouldEqual, ErrLockNotHeld)
+	})
+}
+
+func TestSessionLock(t *testing.T) {
+	manager := NewManager(
+		SetCookieName("test_session_lock"),
+		SetSessionLock(func(r *http.Request) bool {
+			return r.URL.Path == "/checkout"
+		}, time.Millisecond*100),
+	)
+
+	Convey("Test session lock serializes the requests of a session", t, func() {
+		ctx := context.Background()
+		w := httptest.NewRecorder()
+		err := manager.Update(ctx, w, httptest.NewRequest("GET", "/", nil), func(store Store) error {
+			store.Set("count", 0)
+			return nil
+		})
+		So(err, ShouldBeNil)
+		cookies := w.Result().Cookies()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				r := httptest.NewRequest("GET", "/checkout", nil)
+				for _, cookie := range cookies {
+					r.AddCookie(cookie)
+				}
+
+				store, err := manager.Start(ctx, httptest.NewRecorder(), r)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				count, _ := store.Get("count")
+				// hold the lock longer than its ttl, the lease is renewed
+				time.Sleep(time.Millisecond * 120)
+				store.Set("count", count.(int)+1)
+				if err := store.Save(); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		r := httptest.NewRequest("GET", "/", nil)
+		for _, cookie := range cookies {
+			r.AddCookie(cookie)
+		}
+		store, err := manager.Start(ctx, httptest.NewRecorder(), r)
+		So(err, ShouldBeNil)
+		count, _ := store.Get("count")
+		So(count, ShouldEqual, 5)
+	})
+
+	Convey("Test session lock is released when the request is done", t, func() {
+		w := httptest.NewRecorder()
+		store, err := manager.Start(context.Background(), w, httptest.NewRequest("GET", "/", nil))
+		So(err, ShouldBeNil)
+		So(store.Save(), ShouldBeNil)
+
+		r := httptest.NewRequest("GET", "/checkout", nil)
+		for _, cookie := range w.Result().Cookies() {
+			r.AddCookie(cookie)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		_, err = manager.Start(context.Background(), httptest.NewRecorder(), r.WithContext(ctx))
+		So(err, ShouldBeNil)
+		cancel()
+
+		tctx, tcancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+		defer tcancel()
+		store, err = manager.Start(tctx, httptest.NewRecorder(), r)
+		So(err, ShouldBeNil)
+		So(store.Save(), ShouldBeNil)
+	})
+
+	Convey("Test session lock of a request that does not save the session", t, func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// read only, the lock is released when the request is done
+			if _, err := manager.Start(context.Background(), w, r); err != nil {
+				t.Error(err)
+			}
+		}))
+		defer ts.Close()
+
+		w := httptest.NewRecorder()
+		store, err := manager.Start(context.Background(), w, httptest.NewRequest("GET", "/", nil))
+		So(err, ShouldBeNil)
+		So(store.Save(), ShouldBeNil)
+		cookies := w.Result().Cookies()
+
+		_, _, err = getWithCookies(ts.URL+"/checkout", cookies)
+		So(err, ShouldBeNil)
+
+		r := httptest.NewRequest("GET", "/checkout", nil)
+		for _, cookie := range cookies {
+			r.AddCookie(cookie)
+		}
+		tctx, tcancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+		defer tcancel()
+		store, err = manager.Start(tctx, httptest.NewRecorder(), r)
+		So(err, ShouldBeNil)
+		So(store.Save(), ShouldBeNil)
+
+		// released when fn fails
+		fnErr := errors.New("fn failed")
+		err = manager.Update(context.Background(), httptest.NewRecorder(), r, func(store Store) error {
+			return fnErr
+		})
+		So(err, ShouldEqual, fnErr)
+
+		store, err = manager.Start(tctx, httptest.NewRecorder(), r)
+		So(err, ShouldBeNil)
+		So(store.Save(), ShouldBeNil)
+
+		// released by Flush
+		store, err = manager.Start(context.Background(), httptest.NewRecorder(), r)
+		So(err, ShouldBeNil)
+		So(store.Flush(), ShouldBeNil)
+
+		store, err = manager.Start(tctx, httptest.NewRecorder(), r)
+		So(err, ShouldBeNil)
+		So(store.Save(), ShouldBeNil)
+	})
+}
+
+func TestSessionLockWrappedStore(t *testing.T) {
+	lockCheckout := SetSessionLock(func(r *http.Request) bool {
+		return r.URL.Path == "/checkout"
+	}, time.Second)
+
+	start := func(manager *Manager) (Store, error) {
+		w := httptest.NewRecorder()
+		store, err := manager.Start(context.Background(), w, httptest.NewRequest("GET", "/", nil))
+		So(err, ShouldBeNil)
+		So(store.Save(), ShouldBeNil)
+
+		r := httptest.NewRequest("GET", "/checkout", nil)
+		for _, cookie := range w.Result().Cookies() {
+			r.AddCookie(cookie)
+		}
+		if _, err := manager.Start(context.Background(), httptest.NewRecorder(), r); err != nil {
+			return nil, err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+		defer cancel()
+		return manager.Start(ctx, httptest.NewRecorder(), r)
+	}
+
+	Convey("Test session lock through a store wrapper", t, func() {
+		mstore := NewRetryStore(NewMemoryStore())
+		defer mstore.Close()
+
+		_, err := start(NewManager(SetStore(mstore), lockCheckout))
+		So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+	})
+
+	Convey("Test session lock of a store that does not implement Locker", t, func() {
+		fstore, err := NewFileStore(t.TempDir())
+		So(err, ShouldBeNil)
+		defer fstore.Close()
+
+		_, err = start(NewManager(SetStore(fstore), lockCheckout))
+		So(err, ShouldEqual, ErrLockNotSupported)
+
+		mstore := NewRetryStore(fstore)
+		_, err = start(NewManager(SetStore(mstore), lockCheckout))
+		So(err, ShouldEqual, ErrLockNotSupported)
+	})
+}
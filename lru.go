@@ -0,0 +1,145 @@
+package session
+
+import (
+	"container/list"
+	"reflect"
+	"sync"
+	"time"
+)
+
+var _ MemoryStatsStore = &memoryStore{}
+
+// Define the callback of the sessions evicted from a bounded memory store
+type EvictionFunc func(sid string, values map[string]interface{})
+
+// Implemented by the session storages created by NewMemoryStore,
+// e.g. mstore.(session.MemoryStatsStore).Stats()
+type MemoryStatsStore interface {
+	// Get the counters of the memory store
+	Stats() MemoryStoreStats
+}
+
+// The counters of a memory store
+type MemoryStoreStats struct {
+	// The number of stored sessions
+	Sessions int
+	// The approximate size of the stored values (in bytes)
+	Bytes int64
+	// The number of sessions evicted to respect the capacity limits
+	Evictions uint64
+}
+
+// The memory store items ordered by last use, the least recently used first
+// to be evicted when the number of sessions or the size of the values
+// exceeds the capacity limits
+type lruList struct {
+	mu        sync.Mutex
+	items     *list.List
+	bytes     int64
+	evictions uint64
+}
+
+func newLRUList() *lruList {
+	return &lruList{items: list.New()}
+}
+
+// Mark the item as most recently used, size is the approximate size of its
+// values or -1 if the values did not change
+func (l *lruList) touch(item *dataItem, size int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if item.lruElem == nil {
+		if size < 0 {
+			return
+		}
+		item.lruElem = l.items.PushFront(item)
+	} else {
+		l.items.MoveToFront(item.lruElem)
+	}
+
+	if size >= 0 {
+		l.bytes += size - item.lruSize
+		item.lruSize = size
+	}
+}
+
+func (l *lruList) remove(item *dataItem) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.unlink(item)
+}
+
+// l.mu must be held
+func (l *lruList) unlink(item *dataItem) {
+	if item.lruElem == nil {
+		return
+	}
+	l.items.Remove(item.lruElem)
+	l.bytes -= item.lruSize
+	item.lruElem = nil
+	item.lruSize = 0
+}
+
+// Remove the least recently used items until the limits are respected,
+// keep is never selected
+func (l *lruList) victims(maxSessions int, maxBytes int64, keep *dataItem) []*dataItem {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var items []*dataItem
+	elem := l.items.Back()
+	for elem != nil &&
+		((maxSessions > 0 && l.items.Len() > maxSessions) || (maxBytes > 0 && l.bytes > maxBytes)) {
+		prev := elem.Prev()
+		if item := elem.Value.(*dataItem); item != keep {
+			l.unlink(item)
+			l.evictions++
+			items = append(items, item)
+		}
+		elem = prev
+	}
+	return items
+}
+
+func (l *lruList) stats() MemoryStoreStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return MemoryStoreStats{
+		Sessions:  l.items.Len(),
+		Bytes:     l.bytes,
+		Evictions: l.evictions,
+	}
+}
+
+// Estimate the memory used by the session values
+func approxValuesSize(values map[string]interface{}) int64 {
+	var size int64
+	for key, value := range values {
+		size += int64(len(key)) + approxSize(value)
+	}
+	return size
+}
+
+func approxSize(value interface{}) int64 {
+	switch v := value.(type) {
+	case nil:
+		return 0
+	case string:
+		return int64(len(v))
+	case []byte:
+		return int64(len(v))
+	case []string:
+		var size int64
+		for _, s := range v {
+			size += int64(len(s))
+		}
+		return size
+	case map[string]interface{}:
+		return approxValuesSize(v)
+	case time.Time:
+		return 24
+	}
+	return int64(reflect.TypeOf(value).Size())
+}
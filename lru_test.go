@@ -0,0 +1,87 @@
+package session
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func saveMemorySession(mstore ManagerStore, sid string, values map[string]interface{}) {
+	store, err := mstore.Create(context.Background(), sid, 10)
+	So(err, ShouldBeNil)
+	for key, value := range values {
+		store.Set(key, value)
+	}
+	So(store.Save(), ShouldBeNil)
+}
+
+func TestMemoryStoreLRU(t *testing.T) {
+	Convey("Test memory store evicts the least recently used sessions", t, func() {
+		evicted := make(map[string]interface{})
+		mstore := NewMemoryStore(
+			SetMemoryStoreMaxSessions(2),
+			SetMemoryStoreEvictionCallback(func(sid string, values map[string]interface{}) {
+				evicted[sid] = values["foo"]
+			}),
+		)
+		defer mstore.Close()
+
+		ctx := context.Background()
+		saveMemorySession(mstore, "a", map[string]interface{}{"foo": "a"})
+		saveMemorySession(mstore, "b", map[string]interface{}{"foo": "b"})
+
+		_, err := mstore.Update(ctx, "a", 10)
+		So(err, ShouldBeNil)
+		saveMemorySession(mstore, "c", map[string]interface{}{"foo": "c"})
+
+		So(evicted, ShouldResemble, map[string]interface{}{"b": "b"})
+		for sid, exists := range map[string]bool{"a": true, "b": false, "c": true} {
+			ok, err := mstore.Check(ctx, sid)
+			So(err, ShouldBeNil)
+			So(ok, ShouldEqual, exists)
+		}
+
+		stats := mstore.(MemoryStatsStore).Stats()
+		So(stats.Sessions, ShouldEqual, 2)
+		So(stats.Evictions, ShouldEqual, 1)
+
+		So(mstore.Delete(ctx, "a"), ShouldBeNil)
+		stats = mstore.(MemoryStatsStore).Stats()
+		So(stats.Sessions, ShouldEqual, 1)
+		So(stats.Evictions, ShouldEqual, 1)
+	})
+
+	Convey("Test memory store evicts sessions when the values are too large", t, func() {
+		mstore := NewMemoryStore(SetMemoryStoreMaxBytes(250))
+		defer mstore.Close()
+
+		value := strings.Repeat("x", 100)
+		saveMemorySession(mstore, "a", map[string]interface{}{"foo": value})
+		saveMemorySession(mstore, "b", map[string]interface{}{"foo": value})
+
+		stats := mstore.(MemoryStatsStore).Stats()
+		So(stats.Bytes, ShouldEqual, 206)
+		So(stats.Evictions, ShouldEqual, 0)
+
+		saveMemorySession(mstore, "c", map[string]interface{}{"foo": value})
+		stats = mstore.(MemoryStatsStore).Stats()
+		So(stats.Sessions, ShouldEqual, 2)
+		So(stats.Evictions, ShouldEqual, 1)
+
+		exists, err := mstore.Check(context.Background(), "a")
+		So(err, ShouldBeNil)
+		So(exists, ShouldBeFalse)
+
+		// the session that was just written is kept even if it is too large
+		saveMemorySession(mstore, "d", map[string]interface{}{"foo": strings.Repeat("x", 300)})
+		stats = mstore.(MemoryStatsStore).Stats()
+		So(stats.Sessions, ShouldEqual, 1)
+		So(stats.Evictions, ShouldEqual, 3)
+
+		exists, err = mstore.Check(context.Background(), "d")
+		So(err, ShouldBeNil)
+		So(exists, ShouldBeTrue)
+	})
+}
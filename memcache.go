@@ -0,0 +1,332 @@
+package session
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrMemcacheClosed     = errors.New("Memcache client is closed")
+	ErrInvalidMemcacheKey = errors.New("Invalid memcache key")
+	errMemcacheNotFound   = errors.New("memcache: not found")
+	errMemcacheNotStored  = errors.New("memcache: not stored")
+)
+
+// The maximum relative expiration time, larger values are treated by the
+// server as an absolute unix timestamp
+const memcacheMaxRelativeExpiration = 60 * 60 * 24 * 30
+
+type memcacheConn struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+}
+
+// A connection pool of a single memcached server
+type memcacheServer struct {
+	addr string
+	sem  chan struct{}
+	idle chan *memcacheConn
+}
+
+// A minimal memcached text protocol client, keys are distributed over the
+// servers by consistent hashing
+type memcacheClient struct {
+	servers     []*memcacheServer
+	ring        *hashRing
+	dialTimeout time.Duration
+	timeout     time.Duration
+	done        chan struct{}
+	closeOnce   sync.Once
+}
+
+func newMemcacheClient(addrs []string, opts *memcacheStoreOptions) *memcacheClient {
+	c := &memcacheClient{
+		ring:        newHashRing(addrs, opts.replicas),
+		dialTimeout: opts.dialTimeout,
+		timeout:     opts.timeout,
+		done:        make(chan struct{}),
+	}
+
+	for _, addr := range addrs {
+		c.servers = append(c.servers, &memcacheServer{
+			addr: addr,
+			sem:  make(chan struct{}, opts.poolSize),
+			idle: make(chan *memcacheConn, opts.poolSize),
+		})
+	}
+	return c
+}
+
+func validMemcacheKey(key string) bool {
+	if len(key) == 0 || len(key) > 250 {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *memcacheClient) acquire(ctx context.Context, key string) (*memcacheServer, *memcacheConn, error) {
+	if !validMemcacheKey(key) {
+		return nil, nil, ErrInvalidMemcacheKey
+	}
+
+	i := c.ring.get(key)
+	if i < 0 {
+		return nil, nil, errors.New("memcache: no servers configured")
+	}
+	srv := c.servers[i]
+
+	select {
+	case <-c.done:
+		return nil, nil, ErrMemcacheClosed
+	default:
+	}
+
+	select {
+	case <-c.done:
+		return nil, nil, ErrMemcacheClosed
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	case srv.sem <- struct{}{}:
+	}
+
+	select {
+	case cn := <-srv.idle:
+		return srv, cn, nil
+	default:
+	}
+
+	d := net.Dialer{Timeout: c.dialTimeout}
+	conn, err := d.DialContext(ctx, "tcp", srv.addr)
+	if err != nil {
+		<-srv.sem
+		return nil, nil, err
+	}
+	return srv, &memcacheConn{
+		conn: conn,
+		rw:   bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn)),
+	}, nil
+}
+
+func (c *memcacheClient) release(srv *memcacheServer, cn *memcacheConn, broken bool) {
+	defer func() { <-srv.sem }()
+
+	if broken {
+		cn.conn.Close()
+		return
+	}
+
+	select {
+	case <-c.done:
+		cn.conn.Close()
+	case srv.idle <- cn:
+	default:
+		cn.conn.Close()
+	}
+}
+
+// run a command against the server owning the key, protocol level errors
+// (not found, server errors) keep the connection usable
+func (c *memcacheClient) do(ctx context.Context, key string, fn func(rw *bufio.ReadWriter) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	srv, cn, err := c.acquire(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok && c.timeout > 0 {
+		deadline = time.Now().Add(c.timeout)
+	}
+	if err = cn.conn.SetDeadline(deadline); err == nil {
+		err = fn(cn.rw)
+	}
+
+	var merr memcacheError
+	c.release(srv, cn, err != nil && err != errMemcacheNotFound && err != errMemcacheNotStored && !errors.As(err, &merr))
+	return err
+}
+
+// An error reported by the memcached server
+type memcacheError string
+
+func (e memcacheError) Error() string {
+	return "memcache: " + string(e)
+}
+
+func readMemcacheLine(rw *bufio.ReadWriter) (string, error) {
+	line, err := rw.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "ERROR" || strings.HasPrefix(line, "CLIENT_ERROR") || strings.HasPrefix(line, "SERVER_ERROR") {
+		return "", memcacheError(line)
+	}
+	return line, nil
+}
+
+func memcacheExptime(expired int64) int64 {
+	if expired > memcacheMaxRelativeExpiration {
+		return now().Unix() + expired
+	}
+	return expired
+}
+
+func (c *memcacheClient) get(ctx context.Context, key string) ([]byte, error) {
+	value, _, err := c.retrieve(ctx, "get", key)
+	return value, err
+}
+
+// get the value of a key and its cas unique
+func (c *memcacheClient) gets(ctx context.Context, key string) ([]byte, uint64, error) {
+	return c.retrieve(ctx, "gets", key)
+}
+
+func (c *memcacheClient) retrieve(ctx context.Context, cmd, key string) ([]byte, uint64, error) {
+	var (
+		value []byte
+		cas   uint64
+	)
+	err := c.do(ctx, key, func(rw *bufio.ReadWriter) error {
+		fmt.Fprintf(rw, "%s %s\r\n", cmd, key)
+		if err := rw.Flush(); err != nil {
+			return err
+		}
+
+		for {
+			line, err := readMemcacheLine(rw)
+			if err != nil {
+				return err
+			}
+			if line == "END" {
+				break
+			}
+
+			// VALUE <key> <flags> <bytes> [<cas unique>]
+			fields := strings.Fields(line)
+			if len(fields) < 4 || len(fields) > 5 || fields[0] != "VALUE" {
+				return fmt.Errorf("memcache: unexpected response %q", line)
+			}
+			size, err := strconv.Atoi(fields[3])
+			if err != nil {
+				return err
+			}
+			if len(fields) == 5 {
+				if cas, err = strconv.ParseUint(fields[4], 10, 64); err != nil {
+					return err
+				}
+			}
+			buf := make([]byte, size+2)
+			if _, err := io.ReadFull(rw, buf); err != nil {
+				return err
+			}
+			value = buf[:size]
+		}
+
+		if value == nil {
+			return errMemcacheNotFound
+		}
+		return nil
+	})
+	return value, cas, err
+}
+
+func (c *memcacheClient) set(ctx context.Context, key string, value []byte, expired int64) error {
+	return c.store(ctx, "set", key, value, expired, "")
+}
+
+// store the value only if the key does not exist, errMemcacheNotStored otherwise
+func (c *memcacheClient) add(ctx context.Context, key string, value []byte, expired int64) error {
+	return c.store(ctx, "add", key, value, expired, "")
+}
+
+// store the value only if the key was not written since it was read with
+// the cas unique, errMemcacheNotStored or errMemcacheNotFound otherwise
+func (c *memcacheClient) cas(ctx context.Context, key string, value []byte, expired int64, cas uint64) error {
+	return c.store(ctx, "cas", key, value, expired, " "+strconv.FormatUint(cas, 10))
+}
+
+func (c *memcacheClient) store(ctx context.Context, cmd, key string, value []byte, expired int64, args string) error {
+	return c.do(ctx, key, func(rw *bufio.ReadWriter) error {
+		fmt.Fprintf(rw, "%s %s 0 %d %d%s\r\n", cmd, key, memcacheExptime(expired), len(value), args)
+		rw.Write(value)
+		rw.WriteString("\r\n")
+		if err := rw.Flush(); err != nil {
+			return err
+		}
+		return expectMemcacheLine(rw, "STORED")
+	})
+}
+
+func (c *memcacheClient) touch(ctx context.Context, key string, expired int64) error {
+	return c.do(ctx, key, func(rw *bufio.ReadWriter) error {
+		fmt.Fprintf(rw, "touch %s %d\r\n", key, memcacheExptime(expired))
+		if err := rw.Flush(); err != nil {
+			return err
+		}
+		return expectMemcacheLine(rw, "TOUCHED")
+	})
+}
+
+func (c *memcacheClient) delete(ctx context.Context, key string) error {
+	return c.do(ctx, key, func(rw *bufio.ReadWriter) error {
+		fmt.Fprintf(rw, "delete %s\r\n", key)
+		if err := rw.Flush(); err != nil {
+			return err
+		}
+		return expectMemcacheLine(rw, "DELETED")
+	})
+}
+
+func expectMemcacheLine(rw *bufio.ReadWriter, expected string) error {
+	line, err := readMemcacheLine(rw)
+	if err != nil {
+		return err
+	}
+
+	switch line {
+	case expected:
+		return nil
+	case "NOT_FOUND":
+		return errMemcacheNotFound
+	case "NOT_STORED", "EXISTS":
+		return errMemcacheNotStored
+	}
+	return memcacheError(line)
+}
+
+func (c *memcacheClient) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		for _, srv := range c.servers {
+			srv.closeIdle()
+		}
+	})
+}
+
+func (srv *memcacheServer) closeIdle() {
+	for {
+		select {
+		case cn := <-srv.idle:
+			cn.conn.Close()
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package session
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+var (
+	_ ManagerStore = &memcacheStore{}
+	_ VersionStore = &memcacheStore{}
+)
+
+var ErrInvalidMemcacheValue = errors.New("Invalid memcache session value")
+
+// Define default memcache store options
+var defaultMemcacheStoreOptions = memcacheStoreOptions{
+	keyPrefix:   "go_session:",
+	poolSize:    10,
+	replicas:    100,
+	dialTimeout: time.Second * 5,
+	timeout:     time.Second * 3,
+}
+
+type memcacheStoreOptions struct {
+	keyPrefix   string
+	poolSize    int
+	replicas    int
+	dialTimeout time.Duration
+	timeout     time.Duration
+}
+
+type MemcacheStoreOption func(*memcacheStoreOptions)
+
+// Set the prefix of the session keys
+func SetMemcacheStoreKeyPrefix(keyPrefix string) MemcacheStoreOption {
+	return func(o *memcacheStoreOptions) {
+		o.keyPrefix = keyPrefix
+	}
+}
+
+// Set the maximum number of connections to each memcached server
+func SetMemcacheStorePoolSize(poolSize int) MemcacheStoreOption {
+	return func(o *memcacheStoreOptions) {
+		o.poolSize = poolSize
+	}
+}
+
+// Set the number of virtual nodes of each server on the hash ring
+func SetMemcacheStoreReplicas(replicas int) MemcacheStoreOption {
+	return func(o *memcacheStoreOptions) {
+		o.replicas = replicas
+	}
+}
+
+// Set the timeout for establishing new connections
+func SetMemcacheStoreDialTimeout(timeout time.Duration) MemcacheStoreOption {
+	return func(o *memcacheStoreOptions) {
+		o.dialTimeout = timeout
+	}
+}
+
+// Set the timeout of a command when the context has no deadline
+func SetMemcacheStoreTimeout(timeout time.Duration) MemcacheStoreOption {
+	return func(o *memcacheStoreOptions) {
+		o.timeout = timeout
+	}
+}
+
+// Create a new session storage (memcached), sessions are distributed
+// over the servers by consistent hashing
+func NewMemcacheStore(servers []string, opt ...MemcacheStoreOption) ManagerStore {
+	opts := defaultMemcacheStoreOptions
+	for _, o := range opt {
+		o(&opts)
+	}
+	if opts.poolSize <= 0 {
+		opts.poolSize = 1
+	}
+
+	return &memcacheStore{
+		opts:   &opts,
+		client: newMemcacheClient(servers, &opts),
+	}
+}
+
+type memcacheStore struct {
+	opts   *memcacheStoreOptions
+	client *memcacheClient
+}
+
+func (s *memcacheStore) key(sid string) string {
+	return s.opts.keyPrefix + sid
+}
+
+// The stored value is the session version (unix nano of the write, big endian)
+// followed by the encoded session values
+func encodeMemcacheValue(ctx context.Context, values map[string]interface{}, version int64) ([]byte, error) {
+	data, err := encodeValues(ctx, values)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint64(buf, uint64(version))
+	return append(buf, data...), nil
+}
+
+func decodeMemcacheValue(ctx context.Context, data []byte) (map[string]interface{}, int64, error) {
+	if len(data) < 8 {
+		return nil, 0, ErrInvalidMemcacheValue
+	}
+
+	values, err := decodeValues(ctx, data[8:])
+	if err != nil {
+		return nil, 0, err
+	}
+	return values, int64(binary.BigEndian.Uint64(data[:8])), nil
+}
+
+// get a version that differs from the versions written before
+func nextMemcacheVersion(version int64) int64 {
+	next := now().UnixNano()
+	if next <= version {
+		next = version + 1
+	}
+	return next
+}
+
+func (s *memcacheStore) load(ctx context.Context, sid string) (map[string]interface{}, int64, error) {
+	data, err := s.client.get(ctx, s.key(sid))
+	if err == errMemcacheNotFound {
+		return nil, 0, nil
+	} else if err != nil {
+		return nil, 0, err
+	}
+	return decodeMemcacheValue(ctx, data)
+}
+
+func (s *memcacheStore) save(ctx context.Context, sid string, values map[string]interface{}, expired int64) error {
+	data, err := encodeMemcacheValue(ctx, values, nextMemcacheVersion(0))
+	if err != nil {
+		return err
+	}
+	return s.client.set(ctx, s.key(sid), data, expired)
+}
+
+// Write the session with add if it was not persisted yet, with a
+// compare-and-swap of the value read at version otherwise
+func (s *memcacheStore) SaveVersion(ctx context.Context, sid string, values map[string]interface{}, expired int64, version int64) (int64, error) {
+	next := nextMemcacheVersion(version)
+	data, err := encodeMemcacheValue(ctx, values, next)
+	if err != nil {
+		return 0, err
+	}
+
+	if version == 0 {
+		err = s.client.add(ctx, s.key(sid), data, expired)
+	} else {
+		var (
+			stored []byte
+			cas    uint64
+		)
+		stored, cas, err = s.client.gets(ctx, s.key(sid))
+		if err == nil {
+			if len(stored) < 8 || int64(binary.BigEndian.Uint64(stored[:8])) != version {
+				return 0, ErrConflict
+			}
+			err = s.client.cas(ctx, s.key(sid), data, expired, cas)
+		}
+	}
+
+	if err == errMemcacheNotStored || err == errMemcacheNotFound {
+		return 0, ErrConflict
+	} else if err != nil {
+		return 0, err
+	}
+	return next, nil
+}
+
+func (s *memcacheStore) Check(ctx context.Context, sid string) (bool, error) {
+	_, err := s.client.get(ctx, s.key(sid))
+	if err == errMemcacheNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *memcacheStore) Create(ctx context.Context, sid string, expired int64) (Store, error) {
+	return newStore(ctx, s, sid, expired, nil), nil
+}
+
+func (s *memcacheStore) Update(ctx context.Context, sid string, expired int64) (Store, error) {
+	err := s.client.touch(ctx, s.key(sid), expired)
+	if err == errMemcacheNotFound {
+		return newStore(ctx, s, sid, expired, nil), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	values, version, err := s.load(ctx, sid)
+	if err != nil {
+		return nil, err
+	} else if values == nil {
+		return newStore(ctx, s, sid, expired, nil), nil
+	}
+
+	store := newStore(ctx, s, sid, expired, values)
+	store.version = version
+	return store, nil
+}
+
+func (s *memcacheStore) Delete(ctx context.Context, sid string) error {
+	err := s.client.delete(ctx, s.key(sid))
+	if err == errMemcacheNotFound {
+		return nil
+	}
+	return err
+}
+
+func (s *memcacheStore) Refresh(ctx context.Context, oldsid, sid string, expired int64) (Store, error) {
+	if oldsid == sid {
+		return s.Update(ctx, sid, expired)
+	}
+
+	data, err := s.client.get(ctx, s.key(oldsid))
+	if err == errMemcacheNotFound {
+		return newStore(ctx, s, sid, expired, nil), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	values, version, err := decodeMemcacheValue(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.client.set(ctx, s.key(sid), data, expired); err != nil {
+		return nil, err
+	}
+	if err := s.Delete(ctx, oldsid); err != nil {
+		return nil, err
+	}
+	store := newStore(ctx, s, sid, expired, values)
+	store.version = version
+	return store, nil
+}
+
+func (s *memcacheStore) Close() error {
+	s.client.close()
+	return nil
+}
@@ -0,0 +1,257 @@
+package session
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// An in-process server speaking the subset of the memcached text protocol
+// used by the memcache store
+type fakeMemcacheServer struct {
+	ln   net.Listener
+	mu   sync.Mutex
+	data map[string]fakeMemcacheItem
+	cas  uint64
+}
+
+type fakeMemcacheItem struct {
+	value     []byte
+	expiredAt time.Time
+	cas       uint64
+}
+
+func newFakeMemcacheServer(t *testing.T) *fakeMemcacheServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &fakeMemcacheServer{ln: ln, data: make(map[string]fakeMemcacheItem)}
+	go srv.serve()
+	t.Cleanup(func() { ln.Close() })
+	return srv
+}
+
+func (srv *fakeMemcacheServer) addr() string {
+	return srv.ln.Addr().String()
+}
+
+func (srv *fakeMemcacheServer) count() int {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return len(srv.data)
+}
+
+func (srv *fakeMemcacheServer) serve() {
+	for {
+		conn, err := srv.ln.Accept()
+		if err != nil {
+			return
+		}
+		go srv.handle(conn)
+	}
+}
+
+func (srv *fakeMemcacheServer) lookup(key string) (fakeMemcacheItem, bool) {
+	item, ok := srv.data[key]
+	if ok && !item.expiredAt.IsZero() && !item.expiredAt.After(time.Now()) {
+		delete(srv.data, key)
+		return item, false
+	}
+	return item, ok
+}
+
+func fakeMemcacheExpiredAt(exptime string) time.Time {
+	sec, _ := strconv.Atoi(exptime)
+	if sec == 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(time.Duration(sec) * time.Second)
+}
+
+func (srv *fakeMemcacheServer) handle(conn net.Conn) {
+	defer conn.Close()
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+
+	for {
+		line, err := rw.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			rw.WriteString("ERROR\r\n")
+			rw.Flush()
+			continue
+		}
+
+		srv.mu.Lock()
+		switch fields[0] {
+		case "get", "gets":
+			if item, ok := srv.lookup(fields[1]); ok {
+				if fields[0] == "gets" {
+					fmt.Fprintf(rw, "VALUE %s 0 %d %d\r\n%s\r\n", fields[1], len(item.value), item.cas, item.value)
+				} else {
+					fmt.Fprintf(rw, "VALUE %s 0 %d\r\n%s\r\n", fields[1], len(item.value), item.value)
+				}
+			}
+			rw.WriteString("END\r\n")
+		case "set", "add", "cas":
+			size, _ := strconv.Atoi(fields[4])
+			buf := make([]byte, size+2)
+			if _, err := io.ReadFull(rw, buf); err != nil {
+				srv.mu.Unlock()
+				return
+			}
+
+			item, ok := srv.lookup(fields[1])
+			switch {
+			case fields[0] == "add" && ok:
+				rw.WriteString("NOT_STORED\r\n")
+			case fields[0] == "cas" && !ok:
+				rw.WriteString("NOT_FOUND\r\n")
+			case fields[0] == "cas" && fields[5] != strconv.FormatUint(item.cas, 10):
+				rw.WriteString("EXISTS\r\n")
+			default:
+				srv.cas++
+				srv.data[fields[1]] = fakeMemcacheItem{value: buf[:size], expiredAt: fakeMemcacheExpiredAt(fields[3]), cas: srv.cas}
+				rw.WriteString("STORED\r\n")
+			}
+		case "touch":
+			if item, ok := srv.lookup(fields[1]); ok {
+				item.expiredAt = fakeMemcacheExpiredAt(fields[2])
+				srv.data[fields[1]] = item
+				rw.WriteString("TOUCHED\r\n")
+			} else {
+				rw.WriteString("NOT_FOUND\r\n")
+			}
+		case "delete":
+			if _, ok := srv.lookup(fields[1]); ok {
+				delete(srv.data, fields[1])
+				rw.WriteString("DELETED\r\n")
+			} else {
+				rw.WriteString("NOT_FOUND\r\n")
+			}
+		default:
+			rw.WriteString("ERROR\r\n")
+		}
+		srv.mu.Unlock()
+		rw.Flush()
+	}
+}
+
+func TestMemcacheStore(t *testing.T) {
+	srv := newFakeMemcacheServer(t)
+	mstore := NewMemcacheStore([]string{srv.addr()})
+	defer mstore.Close()
+
+	Convey("Test memcache storage operation", t, func() {
+		store, err := mstore.Create(context.Background(), "test_memcache_store", 10)
+		So(err, ShouldBeNil)
+		testStore(store)
+	})
+}
+
+func TestManagerMemcacheStore(t *testing.T) {
+	srv := newFakeMemcacheServer(t)
+	mstore := NewMemcacheStore([]string{srv.addr()})
+	defer mstore.Close()
+
+	Convey("Test memcache-based storage management operations", t, func() {
+		testManagerStore(mstore)
+		testRefreshSameID(mstore)
+
+		_, err := mstore.Check(context.Background(), "invalid session id")
+		So(err, ShouldEqual, ErrInvalidMemcacheKey)
+	})
+}
+
+func TestMemcacheStoreWithExpired(t *testing.T) {
+	srv := newFakeMemcacheServer(t)
+	mstore := NewMemcacheStore([]string{srv.addr()})
+	defer mstore.Close()
+
+	Convey("Test memcache store expiration", t, func() {
+		testStoreWithExpired(mstore)
+	})
+}
+
+func TestMemcacheStoreMultiServer(t *testing.T) {
+	srv1 := newFakeMemcacheServer(t)
+	srv2 := newFakeMemcacheServer(t)
+	mstore := NewMemcacheStore([]string{srv1.addr(), srv2.addr()})
+	defer mstore.Close()
+
+	Convey("Test memcache store consistent hashing across servers", t, func() {
+		for i := 0; i < 100; i++ {
+			store, err := mstore.Create(context.Background(), fmt.Sprintf("test_memcache_multi_%d", i), 10)
+			So(err, ShouldBeNil)
+			store.Set("foo", i)
+			So(store.Save(), ShouldBeNil)
+		}
+
+		So(srv1.count(), ShouldBeGreaterThan, 0)
+		So(srv2.count(), ShouldBeGreaterThan, 0)
+		So(srv1.count()+srv2.count(), ShouldEqual, 100)
+
+		for i := 0; i < 100; i++ {
+			store, err := mstore.Update(context.Background(), fmt.Sprintf("test_memcache_multi_%d", i), 10)
+			So(err, ShouldBeNil)
+			foo, ok := store.Get("foo")
+			So(ok, ShouldBeTrue)
+			So(foo, ShouldEqual, i)
+		}
+	})
+}
+
+func TestMemcacheStoreVersion(t *testing.T) {
+	srv := newFakeMemcacheServer(t)
+	mstore := NewMemcacheStore([]string{srv.addr()})
+	defer mstore.Close()
+
+	Convey("Test memcache store rejects stale session stores", t, func() {
+		ctx := context.Background()
+		sid := "test_memcache_store_version"
+		store, err := mstore.Create(ctx, sid, 10)
+		So(err, ShouldBeNil)
+		store.Set("foo", "bar")
+		So(store.Save(), ShouldBeNil)
+
+		store1, err := mstore.Update(ctx, sid, 10)
+		So(err, ShouldBeNil)
+		store2, err := mstore.Update(ctx, sid, 10)
+		So(err, ShouldBeNil)
+
+		store1.Set("foo1", "bar1")
+		So(store1.Save(), ShouldBeNil)
+		store2.Set("foo2", "bar2")
+		So(store2.Save(), ShouldEqual, ErrConflict)
+
+		store1.Set("foo1", "bar2")
+		So(store1.Save(), ShouldBeNil)
+
+		created, err := mstore.Create(ctx, sid, 10)
+		So(err, ShouldBeNil)
+		created.Set("foo", "baz")
+		So(created.Save(), ShouldEqual, ErrConflict)
+
+		So(mstore.Delete(ctx, sid), ShouldBeNil)
+		store1.Set("foo1", "bar3")
+		So(store1.Save(), ShouldEqual, ErrConflict)
+
+		refreshed, err := mstore.Refresh(ctx, sid, sid+"_new", 10)
+		So(err, ShouldBeNil)
+		refreshed.Set("foo", "baz")
+		So(refreshed.Save(), ShouldBeNil)
+	})
+}
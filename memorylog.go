@@ -0,0 +1,426 @@
+package session
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+var ErrInvalidLogRecord = errors.New("Invalid session log record")
+
+// The maximum size of a log record, larger sizes come from corrupted headers
+const maxLogRecordSize = 64 << 20
+
+// Define when the append-only log of the memory store is synced to disk
+type LogSyncPolicy int
+
+const (
+	// Sync after every record, no acknowledged write is lost on crash
+	LogSyncAlways LogSyncPolicy = iota
+	// Sync once per second, at most a second of writes is lost on crash
+	LogSyncEverySecond
+	// Leave syncing to the operating system
+	LogSyncNever
+)
+
+type logOp byte
+
+const (
+	logOpSave logOp = iota + 1
+	logOpExpire
+	logOpDelete
+	logOpRefresh
+)
+
+// A memory store operation, saves carry the whole session values
+// so that replaying a record more than once is harmless
+type logRecord struct {
+	op        logOp
+	sid       string
+	oldsid    string
+	expiredAt int64
+	version   int64
+	data      []byte
+}
+
+func appendLogBytes(buf, p []byte) []byte {
+	var n [binary.MaxVarintLen64]byte
+	buf = append(buf, n[:binary.PutUvarint(n[:], uint64(len(p)))]...)
+	return append(buf, p...)
+}
+
+func appendLogVarint(buf []byte, v int64) []byte {
+	var n [binary.MaxVarintLen64]byte
+	return append(buf, n[:binary.PutVarint(n[:], v)]...)
+}
+
+// Encode the record in a frame: payload length, crc32 of the payload, payload
+func (rec *logRecord) marshal() []byte {
+	buf := make([]byte, 8, 8+len(rec.sid)+len(rec.oldsid)+len(rec.data)+32)
+	buf = append(buf, byte(rec.op))
+	buf = appendLogBytes(buf, []byte(rec.sid))
+	buf = appendLogBytes(buf, []byte(rec.oldsid))
+	buf = appendLogVarint(buf, rec.expiredAt)
+	buf = appendLogVarint(buf, rec.version)
+	buf = appendLogBytes(buf, rec.data)
+
+	binary.BigEndian.PutUint32(buf[:4], uint32(len(buf)-8))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(buf[8:]))
+	return buf
+}
+
+type logDecoder struct {
+	p []byte
+}
+
+func (d *logDecoder) bytes() ([]byte, error) {
+	n, l := binary.Uvarint(d.p)
+	if l <= 0 || uint64(len(d.p)-l) < n {
+		return nil, ErrInvalidLogRecord
+	}
+	v := d.p[l : l+int(n)]
+	d.p = d.p[l+int(n):]
+	return v, nil
+}
+
+func (d *logDecoder) varint() (int64, error) {
+	v, l := binary.Varint(d.p)
+	if l <= 0 {
+		return 0, ErrInvalidLogRecord
+	}
+	d.p = d.p[l:]
+	return v, nil
+}
+
+func unmarshalLogRecord(p []byte) (logRecord, error) {
+	var rec logRecord
+	if len(p) == 0 {
+		return rec, ErrInvalidLogRecord
+	}
+	rec.op = logOp(p[0])
+	d := &logDecoder{p: p[1:]}
+
+	sid, err := d.bytes()
+	if err != nil {
+		return rec, err
+	}
+	oldsid, err := d.bytes()
+	if err != nil {
+		return rec, err
+	}
+	if rec.expiredAt, err = d.varint(); err != nil {
+		return rec, err
+	}
+	if rec.version, err = d.varint(); err != nil {
+		return rec, err
+	}
+	data, err := d.bytes()
+	if err != nil {
+		return rec, err
+	}
+
+	rec.sid, rec.oldsid = string(sid), string(oldsid)
+	rec.data = append([]byte(nil), data...)
+	return rec, nil
+}
+
+// Read the records of a log file, a torn or corrupted tail (e.g. after a crash)
+// ends the log. Returns the size of the valid part of the file
+func readLogFile(name string, fn func(logRecord) error) (int64, error) {
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var (
+		size   int64
+		header [8]byte
+	)
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return size, nil
+		}
+
+		n := binary.BigEndian.Uint32(header[:4])
+		if n > maxLogRecordSize {
+			return size, nil
+		}
+
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return size, nil
+		}
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:]) {
+			return size, nil
+		}
+
+		rec, err := unmarshalLogRecord(payload)
+		if err != nil {
+			return size, nil
+		}
+		if err := fn(rec); err != nil {
+			return size, err
+		}
+		size += int64(len(header) + len(payload))
+	}
+}
+
+// The append-only log of a memory store. Compaction rotates the log to
+// name.old, writes a snapshot and removes the rotated log, so that a crash
+// at any point is recovered by loading the snapshot and replaying name.old and name
+type appendLog struct {
+	mu     sync.Mutex
+	name   string
+	policy LogSyncPolicy
+	f      *os.File
+	size   int64
+	dirty  bool
+	err    error
+}
+
+func openAppendLog(name string, size int64, policy LogSyncPolicy) *appendLog {
+	l := &appendLog{name: name, policy: policy}
+	l.f, l.err = os.OpenFile(name, os.O_CREATE|os.O_WRONLY, 0600)
+	if l.err != nil {
+		return l
+	}
+
+	// drop the corrupted tail, new records are appended after the valid part
+	if l.err = l.f.Truncate(size); l.err == nil {
+		_, l.err = l.f.Seek(size, io.SeekStart)
+	}
+	l.size = size
+	return l
+}
+
+func (l *appendLog) oldName() string {
+	return l.name + ".old"
+}
+
+func (l *appendLog) append(rec logRecord) error {
+	buf := rec.marshal()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.err != nil {
+		return l.err
+	}
+	if _, err := l.f.Write(buf); err != nil {
+		return err
+	}
+	l.size += int64(len(buf))
+
+	if l.policy == LogSyncAlways {
+		return l.f.Sync()
+	}
+	l.dirty = true
+	return nil
+}
+
+func (l *appendLog) sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.err != nil || !l.dirty {
+		return l.err
+	}
+	l.dirty = false
+	return l.f.Sync()
+}
+
+func (l *appendLog) length() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.size
+}
+
+// Move the records to the rotated log and continue with an empty log. The
+// rotated log of a failed compaction is kept until a snapshot is written
+func (l *appendLog) rotate() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.err != nil {
+		return l.err
+	}
+	if _, err := os.Stat(l.oldName()); err == nil {
+		return nil
+	}
+	if err := l.f.Sync(); err != nil {
+		return err
+	}
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(l.name, l.oldName()); err != nil {
+		l.err = err
+		return err
+	}
+
+	l.f, l.err = os.OpenFile(l.name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	l.size, l.dirty = 0, false
+	return l.err
+}
+
+func (l *appendLog) close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.err != nil {
+		return l.err
+	}
+	l.err = os.ErrClosed
+	if err := l.f.Sync(); err != nil {
+		l.f.Close()
+		return err
+	}
+	return l.f.Close()
+}
+
+// Replay the snapshot and the logs, then open the log for appending
+func (s *memoryStore) openLog() {
+	name := s.opts.logFile
+	_ = s.loadSnapshot(s.opts.snapshotFile)
+
+	_, _ = readLogFile(name+".old", s.applyLogRecord)
+	size, err := readLogFile(name, s.applyLogRecord)
+	if err != nil {
+		s.log = &appendLog{name: name, err: err}
+		return
+	}
+	s.log = openAppendLog(name, size, s.opts.logSync)
+}
+
+func (s *memoryStore) applyLogRecord(rec logRecord) error {
+	switch rec.op {
+	case logOpSave:
+		values, err := defaultCodec.Unmarshal(rec.data)
+		if err != nil {
+			return err
+		}
+		item := s.lockItem(rec.sid, 0)
+		s.setExpiredAt(item, time.Unix(0, rec.expiredAt))
+		s.write(item, values, nil)
+		item.version, item.writtenAt = rec.version, rec.version
+		s.unlockItem(item)
+	case logOpExpire:
+		if item, ok := s.lockExisting(rec.sid); ok {
+			s.setExpiredAt(item, time.Unix(0, rec.expiredAt))
+			item.mu.Unlock()
+		}
+	case logOpDelete:
+		if item, ok := s.lockExisting(rec.sid); ok {
+			s.remove(item)
+			item.mu.Unlock()
+		}
+	case logOpRefresh:
+		if item, ok := s.lockExisting(rec.oldsid); ok {
+			s.moveItem(item, rec.sid, time.Unix(0, rec.expiredAt))
+			item.mu.Unlock()
+		}
+	default:
+		return ErrInvalidLogRecord
+	}
+	return nil
+}
+
+// Append the record if the log is enabled
+func (s *memoryStore) appendLog(rec logRecord) error {
+	if s.log == nil {
+		return nil
+	}
+
+	if err := s.log.append(rec); err != nil {
+		return err
+	}
+	if s.opts.logCompactSize > 0 && s.log.length() >= s.opts.logCompactSize {
+		select {
+		case s.compactc <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Write the values of the item and append them to the log, item.mu must be held.
+// The values are encoded first, values that can not be logged are rejected
+// and the item is left unchanged
+func (s *memoryStore) commit(item *dataItem, values map[string]interface{}, patched []string) (int64, error) {
+	if s.log == nil {
+		return s.write(item, values, patched), nil
+	}
+
+	data, err := defaultCodec.Marshal(values)
+	if err != nil {
+		return 0, err
+	}
+
+	version := s.write(item, values, patched)
+	return version, s.appendSave(item, data)
+}
+
+// Append the values of the item, item.mu must be held
+func (s *memoryStore) logSave(item *dataItem) error {
+	if s.log == nil {
+		return nil
+	}
+
+	data, err := defaultCodec.Marshal(item.values)
+	if err != nil {
+		return err
+	}
+	return s.appendSave(item, data)
+}
+
+// Append a save record of the item with its encoded values, item.mu must be held
+func (s *memoryStore) appendSave(item *dataItem, data []byte) error {
+	return s.appendLog(logRecord{
+		op:        logOpSave,
+		sid:       item.sid,
+		expiredAt: item.expiredAt.UnixNano(),
+		version:   item.version,
+		data:      data,
+	})
+}
+
+// Sync the log periodically and compact it when it grows too large
+func (s *memoryStore) maintainLog() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if s.opts.logSync == LogSyncEverySecond {
+				_ = s.log.sync()
+			}
+		case <-s.compactc:
+			_ = s.compact()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Write the sessions into the snapshot file and drop the replayed records
+func (s *memoryStore) compact() error {
+	if err := s.log.rotate(); err != nil {
+		return err
+	}
+	if err := s.saveSnapshot(s.opts.snapshotFile); err != nil {
+		return err
+	}
+	return os.Remove(s.log.oldName())
+}
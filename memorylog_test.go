@@ -0,0 +1,166 @@
+package session
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// stop the memory store without compacting the log, as if the process crashed
+func crashMemoryStore(mstore ManagerStore) {
+	s := mstore.(*memoryStore)
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.wg.Wait()
+		_ = s.log.close()
+	})
+}
+
+func getMemorySession(mstore ManagerStore, sid string) (interface{}, bool) {
+	store, err := mstore.Update(context.Background(), sid, 10)
+	So(err, ShouldBeNil)
+	return store.Get("foo")
+}
+
+// A value type not registered with gob
+type unregisteredValue struct {
+	X int
+}
+
+func TestMemoryStoreLog(t *testing.T) {
+	Convey("Test memory store log replay", t, func() {
+		name := filepath.Join(t.TempDir(), "sessions.log")
+		ctx := context.Background()
+
+		mstore := NewMemoryStore(SetMemoryStoreLogFile(name), SetMemoryStoreLogSync(LogSyncAlways))
+		saveMemorySession(mstore, "a", map[string]interface{}{"foo": "a"})
+		saveMemorySession(mstore, "b", map[string]interface{}{"foo": "b"})
+		saveMemorySession(mstore, "c", map[string]interface{}{"foo": "c"})
+
+		store, err := mstore.Update(ctx, "a", 10)
+		So(err, ShouldBeNil)
+		store.Set("foo", "a2")
+		So(store.Save(), ShouldBeNil)
+
+		So(mstore.Delete(ctx, "b"), ShouldBeNil)
+		_, err = mstore.Refresh(ctx, "c", "d", 10)
+		So(err, ShouldBeNil)
+		crashMemoryStore(mstore)
+
+		// a torn record written by the crash
+		f, err := os.OpenFile(name, os.O_APPEND|os.O_WRONLY, 0600)
+		So(err, ShouldBeNil)
+		_, err = f.Write([]byte{0, 0, 0, 10, 1, 2})
+		So(err, ShouldBeNil)
+		So(f.Close(), ShouldBeNil)
+
+		mstore = NewMemoryStore(SetMemoryStoreLogFile(name))
+		foo, ok := getMemorySession(mstore, "a")
+		So(ok, ShouldBeTrue)
+		So(foo, ShouldEqual, "a2")
+		foo, ok = getMemorySession(mstore, "d")
+		So(ok, ShouldBeTrue)
+		So(foo, ShouldEqual, "c")
+		for _, sid := range []string{"b", "c"} {
+			exists, err := mstore.Check(ctx, sid)
+			So(err, ShouldBeNil)
+			So(exists, ShouldBeFalse)
+		}
+
+		// appended after the torn record was dropped
+		saveMemorySession(mstore, "e", map[string]interface{}{"foo": "e"})
+		crashMemoryStore(mstore)
+
+		mstore = NewMemoryStore(SetMemoryStoreLogFile(name))
+		defer mstore.Close()
+		foo, ok = getMemorySession(mstore, "e")
+		So(ok, ShouldBeTrue)
+		So(foo, ShouldEqual, "e")
+		So(mstore.(MemoryStatsStore).Stats().Sessions, ShouldEqual, 3)
+	})
+
+	Convey("Test memory store rejects values that can not be logged", t, func() {
+		name := filepath.Join(t.TempDir(), "sessions.log")
+		ctx := context.Background()
+
+		mstore := NewMemoryStore(SetMemoryStoreLogFile(name))
+		store, err := mstore.Create(ctx, "a", 10)
+		So(err, ShouldBeNil)
+		store.Set("foo", unregisteredValue{X: 1})
+		So(store.Save(), ShouldNotBeNil)
+		exists, err := mstore.Check(ctx, "a")
+		So(err, ShouldBeNil)
+		So(exists, ShouldBeFalse)
+
+		saveMemorySession(mstore, "b", map[string]interface{}{"foo": "b"})
+		store, err = mstore.Update(ctx, "b", 10)
+		So(err, ShouldBeNil)
+		store.Set("foo", unregisteredValue{X: 1})
+		So(store.Save(), ShouldNotBeNil)
+
+		// unchanged, the session can still be saved
+		store, err = mstore.Update(ctx, "b", 10)
+		So(err, ShouldBeNil)
+		foo, _ := store.Get("foo")
+		So(foo, ShouldEqual, "b")
+		store.Set("foo", "c")
+		So(store.Save(), ShouldBeNil)
+		So(mstore.Close(), ShouldBeNil)
+
+		mstore = NewMemoryStore(SetMemoryStoreLogFile(name))
+		defer mstore.Close()
+		foo, ok := getMemorySession(mstore, "b")
+		So(ok, ShouldBeTrue)
+		So(foo, ShouldEqual, "c")
+	})
+
+	Convey("Test memory store log compaction", t, func() {
+		name := filepath.Join(t.TempDir(), "sessions.log")
+
+		mstore := NewMemoryStore(SetMemoryStoreLogFile(name), SetMemoryStoreLogCompactSize(1024))
+		for i := 0; i < 20; i++ {
+			store, err := mstore.Update(context.Background(), "a", 10)
+			So(err, ShouldBeNil)
+			store.Set("foo", i)
+			So(store.Save(), ShouldBeNil)
+		}
+
+		deadline := time.Now().Add(time.Second * 5)
+		for {
+			if _, err := os.Stat(name + ".snapshot"); err == nil {
+				break
+			}
+			So(time.Now().Before(deadline), ShouldBeTrue)
+			time.Sleep(time.Millisecond * 10)
+		}
+		So(mstore.(*memoryStore).log.length(), ShouldBeLessThan, 1024)
+
+		saveMemorySession(mstore, "b", map[string]interface{}{"foo": "b"})
+		crashMemoryStore(mstore)
+
+		mstore = NewMemoryStore(SetMemoryStoreLogFile(name))
+		foo, ok := getMemorySession(mstore, "a")
+		So(ok, ShouldBeTrue)
+		So(foo, ShouldEqual, 19)
+		foo, ok = getMemorySession(mstore, "b")
+		So(ok, ShouldBeTrue)
+		So(foo, ShouldEqual, "b")
+
+		So(mstore.Close(), ShouldBeNil)
+		info, err := os.Stat(name)
+		So(err, ShouldBeNil)
+		So(info.Size(), ShouldEqual, 0)
+		_, err = os.Stat(name + ".old")
+		So(os.IsNotExist(err), ShouldBeTrue)
+
+		mstore = NewMemoryStore(SetMemoryStoreLogFile(name))
+		defer mstore.Close()
+		foo, ok = getMemorySession(mstore, "b")
+		So(ok, ShouldBeTrue)
+		So(foo, ShouldEqual, "b")
+	})
+}
@@ -0,0 +1,135 @@
+package session
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+)
+
+var ErrNoSessionInContext = errors.New("No session in the context, the request is not served by the session middleware")
+
+// The session of a request served by the middleware, started on first use
+type lazySession struct {
+	mu      sync.Mutex
+	manager *Manager
+	w       http.ResponseWriter
+	r       *http.Request
+	store   Store
+	err     error
+}
+
+func (s *lazySession) start() (Store, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.store == nil && s.err == nil {
+		s.store, s.err = s.manager.Start(s.r.Context(), s.w, s.r)
+	}
+	return s.store, s.err
+}
+
+// save the session if it was started
+func (s *lazySession) save() (bool, error) {
+	s.mu.Lock()
+	store := s.store
+	s.mu.Unlock()
+
+	if store == nil {
+		return false, nil
+	}
+	return true, store.Save()
+}
+
+// A response writer that saves the session before the response header
+// (and the session cookie) is sent
+type sessionResponseWriter struct {
+	http.ResponseWriter
+	sess        *lazySession
+	saved       bool
+	saveErr     error
+	wroteHeader bool
+}
+
+// save the session once it is started, store errors are reported to
+// the error handler instead of the response of the wrapped handler,
+// or to the late error handler once the response header was sent
+func (w *sessionResponseWriter) save() error {
+	if w.saved || w.saveErr != nil {
+		return w.saveErr
+	}
+
+	started, err := w.sess.save()
+	if err != nil {
+		w.saveErr = err
+		if w.wroteHeader {
+			w.sess.manager.opts.lateErrorHandler(w.sess.r, err)
+		} else {
+			w.sess.manager.opts.errorHandler(w.ResponseWriter, w.sess.r, err)
+		}
+	}
+	w.saved = started
+	return w.saveErr
+}
+
+func (w *sessionResponseWriter) WriteHeader(statusCode int) {
+	if w.save() != nil {
+		return
+	}
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *sessionResponseWriter) Write(b []byte) (int, error) {
+	if err := w.save(); err != nil {
+		return 0, err
+	}
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *sessionResponseWriter) Flush() {
+	if w.save() != nil {
+		return
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.wroteHeader = true
+		f.Flush()
+	}
+}
+
+func (w *sessionResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	w.wroteHeader = true
+	return h.Hijack()
+}
+
+// Unwrap returns the original ResponseWriter (used by http.ResponseController)
+func (w *sessionResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Wrap the handler to start the session lazily and save it automatically,
+// the handler gets the session with FromContext(r.Context()).
+// The session is saved before the first byte of the response is written
+// and again when the handler returns, store errors are passed to the error handler,
+// or to the late error handler once the response header was sent.
+func (m *Manager) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sess := &lazySession{manager: m}
+		sw := &sessionResponseWriter{ResponseWriter: w, sess: sess}
+
+		r = r.WithContext(newSessionContext(r.Context(), sess))
+		sess.w, sess.r = sw, r
+
+		next.ServeHTTP(sw, r)
+
+		// save the changes made after the response header was sent
+		sw.saved = false
+		_ = sw.save()
+	})
+}
@@ -0,0 +1,161 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMiddleware(t *testing.T) {
+	cookieName := "test_middleware"
+	manager := NewManager(SetCookieName(cookieName))
+
+	ts := httptest.NewServer(manager.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("anonymous") == "1" {
+			fmt.Fprint(w, "ok")
+			return
+		}
+
+		store, err := FromContext(r.Context())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if r.URL.Query().Get("login") == "1" {
+			foo, ok := store.Get("foo")
+			fmt.Fprintf(w, "%v:%v", foo, ok)
+			return
+		}
+
+		if r.URL.Query().Get("late") == "1" {
+			w.WriteHeader(http.StatusOK)
+			fmt.Fprint(w, "ok")
+			store, err = FromContext(r.Context())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			store.Set("foo", "late")
+			return
+		}
+
+		// saved by the middleware
+		store.Set("foo", "bar")
+		fmt.Fprint(w, "ok")
+	})))
+	defer ts.Close()
+
+	Convey("Test middleware saves the session automatically", t, func() {
+		res, err := http.Get(ts.URL)
+		So(err, ShouldBeNil)
+		So(len(res.Cookies()), ShouldEqual, 1)
+
+		cookie := res.Cookies()[0]
+		So(cookie.Name, ShouldEqual, cookieName)
+
+		body, _, err := getWithCookies(ts.URL+"?login=1", []*http.Cookie{cookie})
+		So(err, ShouldBeNil)
+		So(body, ShouldEqual, "bar:true")
+	})
+
+	Convey("Test middleware saves a session changed after the response header", t, func() {
+		res, err := http.Get(ts.URL)
+		So(err, ShouldBeNil)
+		cookie := res.Cookies()[0]
+
+		_, _, err = getWithCookies(ts.URL+"?late=1", []*http.Cookie{cookie})
+		So(err, ShouldBeNil)
+
+		body, _, err := getWithCookies(ts.URL+"?login=1", []*http.Cookie{cookie})
+		So(err, ShouldBeNil)
+		So(body, ShouldEqual, "late:true")
+	})
+
+	Convey("Test middleware starts the session lazily", t, func() {
+		res, err := http.Get(ts.URL + "?anonymous=1")
+		So(err, ShouldBeNil)
+		So(len(res.Cookies()), ShouldEqual, 0)
+	})
+}
+
+func TestMiddlewareErrorHandler(t *testing.T) {
+	mstore, err := NewCookieStore([][]byte{testCookieKey}, SetCookieStoreMaxSize(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var handledErr error
+	manager := NewManager(
+		SetStore(mstore),
+		SetErrorHandler(func(w http.ResponseWriter, r *http.Request, err error) {
+			handledErr = err
+			http.Error(w, "save failed", http.StatusServiceUnavailable)
+		}),
+	)
+
+	handler := manager.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		store, err := FromContext(r.Context())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		store.Set("foo", "bar")
+		if _, err := fmt.Fprint(w, "ok"); err == nil {
+			t.Error("Not expected value")
+		}
+	}))
+
+	Convey("Test middleware reports save errors to the error handler", t, func() {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+		So(handledErr, ShouldEqual, ErrCookieTooLarge)
+		So(w.Code, ShouldEqual, http.StatusServiceUnavailable)
+
+		buf, err := io.ReadAll(w.Body)
+		So(err, ShouldBeNil)
+		So(string(buf), ShouldEqual, "save failed\n")
+	})
+
+	var lateErr error
+	lateManager := NewManager(
+		SetStore(mstore),
+		SetLateErrorHandler(func(r *http.Request, err error) {
+			lateErr = err
+		}),
+	)
+	lateHandler := lateManager.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "payload")
+
+		store, err := FromContext(r.Context())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		store.Set("foo", "bar")
+	}))
+
+	Convey("Test middleware does not change a sent response on save errors", t, func() {
+		w := httptest.NewRecorder()
+		lateHandler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+		So(lateErr, ShouldEqual, ErrCookieTooLarge)
+		So(w.Code, ShouldEqual, http.StatusOK)
+
+		buf, err := io.ReadAll(w.Body)
+		So(err, ShouldBeNil)
+		So(string(buf), ShouldEqual, "payload")
+	})
+
+	Convey("Test session is not available without the middleware", t, func() {
+		_, err := FromContext(context.Background())
+		So(err, ShouldEqual, ErrNoSessionInContext)
+	})
+}
@@ -0,0 +1,260 @@
+package session
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var ErrRedisPoolClosed = errors.New("Redis connection pool is closed")
+
+// An error reply returned by the redis server
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+type redisConn struct {
+	conn net.Conn
+	rd   *bufio.Reader
+	wr   *bufio.Writer
+}
+
+// write a command as an array of bulk strings
+func (c *redisConn) writeCommand(args ...interface{}) error {
+	fmt.Fprintf(c.wr, "*%d\r\n", len(args))
+	for _, arg := range args {
+		var b []byte
+		switch v := arg.(type) {
+		case string:
+			b = []byte(v)
+		case []byte:
+			b = v
+		case int:
+			b = strconv.AppendInt(nil, int64(v), 10)
+		case int64:
+			b = strconv.AppendInt(nil, v, 10)
+		default:
+			b = []byte(fmt.Sprint(v))
+		}
+		fmt.Fprintf(c.wr, "$%d\r\n", len(b))
+		c.wr.Write(b)
+		c.wr.WriteString("\r\n")
+	}
+	return c.wr.Flush()
+}
+
+func (c *redisConn) readLine() (string, error) {
+	line, err := c.rd.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("redis: invalid reply line %q", line)
+	}
+	return line[:len(line)-2], nil
+}
+
+// read a reply, the result is one of string, int64, []byte, []interface{},
+// redisError or nil
+func (c *redisConn) readReply() (interface{}, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return redisError(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.rd, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = c.readReply(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply type %q", line[0])
+}
+
+// A minimal RESP client with a bounded connection pool
+type redisPool struct {
+	addr        string
+	password    string
+	db          int
+	dialTimeout time.Duration
+	timeout     time.Duration
+
+	sem       chan struct{}
+	idle      chan *redisConn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newRedisPool(addr string, opts *redisStoreOptions) *redisPool {
+	return &redisPool{
+		addr:        addr,
+		password:    opts.password,
+		db:          opts.db,
+		dialTimeout: opts.dialTimeout,
+		timeout:     opts.timeout,
+		sem:         make(chan struct{}, opts.poolSize),
+		idle:        make(chan *redisConn, opts.poolSize),
+		done:        make(chan struct{}),
+	}
+}
+
+func (p *redisPool) dial(ctx context.Context) (*redisConn, error) {
+	d := net.Dialer{Timeout: p.dialTimeout}
+	conn, err := d.DialContext(ctx, "tcp", p.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &redisConn{
+		conn: conn,
+		rd:   bufio.NewReader(conn),
+		wr:   bufio.NewWriter(conn),
+	}
+
+	var setup [][]interface{}
+	if p.password != "" {
+		setup = append(setup, []interface{}{"AUTH", p.password})
+	}
+	if p.db != 0 {
+		setup = append(setup, []interface{}{"SELECT", p.db})
+	}
+	for _, args := range setup {
+		if _, err := p.exec(ctx, c, args...); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (p *redisPool) get(ctx context.Context) (*redisConn, error) {
+	select {
+	case <-p.done:
+		return nil, ErrRedisPoolClosed
+	default:
+	}
+
+	select {
+	case <-p.done:
+		return nil, ErrRedisPoolClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case p.sem <- struct{}{}:
+	}
+
+	select {
+	case c := <-p.idle:
+		return c, nil
+	default:
+	}
+
+	c, err := p.dial(ctx)
+	if err != nil {
+		<-p.sem
+		return nil, err
+	}
+	return c, nil
+}
+
+func (p *redisPool) put(c *redisConn, broken bool) {
+	defer func() { <-p.sem }()
+
+	if broken {
+		c.conn.Close()
+		return
+	}
+
+	select {
+	case <-p.done:
+		c.conn.Close()
+	case p.idle <- c:
+	default:
+		c.conn.Close()
+	}
+}
+
+// send a command and read its reply, the deadline of the context takes
+// precedence over the configured timeout
+func (p *redisPool) exec(ctx context.Context, c *redisConn, args ...interface{}) (interface{}, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok && p.timeout > 0 {
+		deadline = time.Now().Add(p.timeout)
+	}
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	if err := c.writeCommand(args...); err != nil {
+		return nil, err
+	}
+	reply, err := c.readReply()
+	if err != nil {
+		return nil, err
+	}
+	if rerr, ok := reply.(redisError); ok {
+		return nil, rerr
+	}
+	return reply, nil
+}
+
+func (p *redisPool) do(ctx context.Context, args ...interface{}) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	c, err := p.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := p.exec(ctx, c, args...)
+	var rerr redisError
+	p.put(c, err != nil && !errors.As(err, &rerr))
+	return reply, err
+}
+
+func (p *redisPool) close() {
+	p.closeOnce.Do(func() {
+		close(p.done)
+		for {
+			select {
+			case c := <-p.idle:
+				c.conn.Close()
+			default:
+				return
+			}
+		}
+	})
+}
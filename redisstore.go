@@ -0,0 +1,175 @@
+package session
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+var _ ManagerStore = &redisStore{}
+
+// Define default redis store options
+var defaultRedisStoreOptions = redisStoreOptions{
+	keyPrefix:   "go_session:",
+	poolSize:    10,
+	dialTimeout: time.Second * 5,
+	timeout:     time.Second * 3,
+}
+
+type redisStoreOptions struct {
+	keyPrefix   string
+	poolSize    int
+	dialTimeout time.Duration
+	timeout     time.Duration
+	password    string
+	db          int
+}
+
+type RedisStoreOption func(*redisStoreOptions)
+
+// Set the prefix of the session keys
+func SetRedisStoreKeyPrefix(keyPrefix string) RedisStoreOption {
+	return func(o *redisStoreOptions) {
+		o.keyPrefix = keyPrefix
+	}
+}
+
+// Set the maximum number of connections to the redis server
+func SetRedisStorePoolSize(poolSize int) RedisStoreOption {
+	return func(o *redisStoreOptions) {
+		o.poolSize = poolSize
+	}
+}
+
+// Set the timeout for establishing new connections
+func SetRedisStoreDialTimeout(timeout time.Duration) RedisStoreOption {
+	return func(o *redisStoreOptions) {
+		o.dialTimeout = timeout
+	}
+}
+
+// Set the timeout of a command when the context has no deadline
+func SetRedisStoreTimeout(timeout time.Duration) RedisStoreOption {
+	return func(o *redisStoreOptions) {
+		o.timeout = timeout
+	}
+}
+
+// Set the password used to authenticate new connections
+func SetRedisStorePassword(password string) RedisStoreOption {
+	return func(o *redisStoreOptions) {
+		o.password = password
+	}
+}
+
+// Set the database selected by new connections
+func SetRedisStoreDB(db int) RedisStoreOption {
+	return func(o *redisStoreOptions) {
+		o.db = db
+	}
+}
+
+// Create a new session storage (redis), connections to addr are
+// established on demand
+func NewRedisStore(addr string, opt ...RedisStoreOption) ManagerStore {
+	opts := defaultRedisStoreOptions
+	for _, o := range opt {
+		o(&opts)
+	}
+	if opts.poolSize <= 0 {
+		opts.poolSize = 1
+	}
+
+	return &redisStore{
+		opts: &opts,
+		pool: newRedisPool(addr, &opts),
+	}
+}
+
+type redisStore struct {
+	opts *redisStoreOptions
+	pool *redisPool
+}
+
+func (s *redisStore) key(sid string) string {
+	return s.opts.keyPrefix + sid
+}
+
+func (s *redisStore) load(ctx context.Context, sid string) (map[string]interface{}, error) {
+	reply, err := s.pool.do(ctx, "GET", s.key(sid))
+	if err != nil {
+		return nil, err
+	}
+
+	data, ok := reply.([]byte)
+	if !ok {
+		return nil, nil
+	}
+	return decodeValues(ctx, data)
+}
+
+func (s *redisStore) save(ctx context.Context, sid string, values map[string]interface{}, expired int64) error {
+	data, err := encodeValues(ctx, values)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pool.do(ctx, "SET", s.key(sid), data, "EX", expired)
+	return err
+}
+
+func (s *redisStore) Check(ctx context.Context, sid string) (bool, error) {
+	reply, err := s.pool.do(ctx, "EXISTS", s.key(sid))
+	if err != nil {
+		return false, err
+	}
+	return reply == int64(1), nil
+}
+
+func (s *redisStore) Create(ctx context.Context, sid string, expired int64) (Store, error) {
+	return newStore(ctx, s, sid, expired, nil), nil
+}
+
+func (s *redisStore) Update(ctx context.Context, sid string, expired int64) (Store, error) {
+	values, err := s.load(ctx, sid)
+	if err != nil {
+		return nil, err
+	} else if values == nil {
+		return newStore(ctx, s, sid, expired, nil), nil
+	}
+
+	if _, err := s.pool.do(ctx, "EXPIRE", s.key(sid), expired); err != nil {
+		return nil, err
+	}
+	return newStore(ctx, s, sid, expired, values), nil
+}
+
+func (s *redisStore) Delete(ctx context.Context, sid string) error {
+	_, err := s.pool.do(ctx, "DEL", s.key(sid))
+	return err
+}
+
+func (s *redisStore) Refresh(ctx context.Context, oldsid, sid string, expired int64) (Store, error) {
+	_, err := s.pool.do(ctx, "RENAME", s.key(oldsid), s.key(sid))
+	if err != nil {
+		if rerr, ok := err.(redisError); ok && strings.Contains(string(rerr), "no such key") {
+			return newStore(ctx, s, sid, expired, nil), nil
+		}
+		return nil, err
+	}
+
+	if _, err := s.pool.do(ctx, "EXPIRE", s.key(sid), expired); err != nil {
+		return nil, err
+	}
+
+	values, err := s.load(ctx, sid)
+	if err != nil {
+		return nil, err
+	}
+	return newStore(ctx, s, sid, expired, values), nil
+}
+
+func (s *redisStore) Close() error {
+	s.pool.close()
+	return nil
+}
@@ -0,0 +1,267 @@
+package session
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// An in-process server speaking the subset of RESP used by the redis store
+type fakeRedisServer struct {
+	ln    net.Listener
+	mu    sync.Mutex
+	data  map[string]fakeRedisItem
+	conns int
+}
+
+type fakeRedisItem struct {
+	value     []byte
+	expiredAt time.Time
+}
+
+func newFakeRedisServer(t *testing.T) *fakeRedisServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &fakeRedisServer{ln: ln, data: make(map[string]fakeRedisItem)}
+	go srv.serve()
+	t.Cleanup(func() { ln.Close() })
+	return srv
+}
+
+func (srv *fakeRedisServer) addr() string {
+	return srv.ln.Addr().String()
+}
+
+func (srv *fakeRedisServer) connCount() int {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.conns
+}
+
+func (srv *fakeRedisServer) serve() {
+	for {
+		conn, err := srv.ln.Accept()
+		if err != nil {
+			return
+		}
+		srv.mu.Lock()
+		srv.conns++
+		srv.mu.Unlock()
+		go srv.handle(conn)
+	}
+}
+
+func (srv *fakeRedisServer) handle(conn net.Conn) {
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+
+	for {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			return
+		}
+		n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		args := make([]string, n)
+		for i := range args {
+			line, err = rd.ReadString('\n')
+			if err != nil {
+				return
+			}
+			size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+			buf := make([]byte, size+2)
+			if _, err := io.ReadFull(rd, buf); err != nil {
+				return
+			}
+			args[i] = string(buf[:size])
+		}
+		conn.Write([]byte(srv.exec(args)))
+	}
+}
+
+func (srv *fakeRedisServer) get(key string) (fakeRedisItem, bool) {
+	item, ok := srv.data[key]
+	if ok && !item.expiredAt.IsZero() && !item.expiredAt.After(time.Now()) {
+		delete(srv.data, key)
+		return item, false
+	}
+	return item, ok
+}
+
+func (srv *fakeRedisServer) exec(args []string) string {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	switch strings.ToUpper(args[0]) {
+	case "PING", "AUTH", "SELECT":
+		return "+OK\r\n"
+	case "GET":
+		item, ok := srv.get(args[1])
+		if !ok {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(item.value), item.value)
+	case "SET":
+		item := fakeRedisItem{value: []byte(args[2])}
+		if len(args) == 5 && strings.ToUpper(args[3]) == "EX" {
+			sec, _ := strconv.Atoi(args[4])
+			item.expiredAt = time.Now().Add(time.Duration(sec) * time.Second)
+		}
+		srv.data[args[1]] = item
+		return "+OK\r\n"
+	case "EXISTS":
+		if _, ok := srv.get(args[1]); ok {
+			return ":1\r\n"
+		}
+		return ":0\r\n"
+	case "DEL":
+		if _, ok := srv.get(args[1]); ok {
+			delete(srv.data, args[1])
+			return ":1\r\n"
+		}
+		return ":0\r\n"
+	case "EXPIRE":
+		item, ok := srv.get(args[1])
+		if !ok {
+			return ":0\r\n"
+		}
+		sec, _ := strconv.Atoi(args[2])
+		item.expiredAt = time.Now().Add(time.Duration(sec) * time.Second)
+		srv.data[args[1]] = item
+		return ":1\r\n"
+	case "RENAME":
+		item, ok := srv.get(args[1])
+		if !ok {
+			return "-ERR no such key\r\n"
+		}
+		delete(srv.data, args[1])
+		srv.data[args[2]] = item
+		return "+OK\r\n"
+	}
+	return "-ERR unknown command\r\n"
+}
+
+func TestRedisStore(t *testing.T) {
+	srv := newFakeRedisServer(t)
+	mstore := NewRedisStore(srv.addr())
+	defer mstore.Close()
+
+	Convey("Test redis storage operation", t, func() {
+		store, err := mstore.Create(context.Background(), "test_redis_store", 10)
+		So(err, ShouldBeNil)
+		testStore(store)
+	})
+}
+
+func TestManagerRedisStore(t *testing.T) {
+	srv := newFakeRedisServer(t)
+	mstore := NewRedisStore(srv.addr(),
+		SetRedisStoreKeyPrefix("test:"),
+		SetRedisStorePassword("secret"),
+		SetRedisStoreDB(1),
+	)
+	defer mstore.Close()
+
+	Convey("Test redis-based storage management operations", t, func() {
+		testManagerStore(mstore)
+
+		store, err := mstore.Create(context.Background(), "test_redis_prefix", 10)
+		So(err, ShouldBeNil)
+		So(store.Save(), ShouldBeNil)
+
+		srv.mu.Lock()
+		_, ok := srv.data["test:test_redis_prefix"]
+		srv.mu.Unlock()
+		So(ok, ShouldBeTrue)
+	})
+}
+
+func TestRedisStoreWithExpired(t *testing.T) {
+	srv := newFakeRedisServer(t)
+	mstore := NewRedisStore(srv.addr())
+	defer mstore.Close()
+
+	Convey("Test redis store expiration", t, func() {
+		testStoreWithExpired(mstore)
+	})
+}
+
+func TestRedisStorePool(t *testing.T) {
+	srv := newFakeRedisServer(t)
+	mstore := NewRedisStore(srv.addr(), SetRedisStorePoolSize(2))
+
+	Convey("Test redis store connection pooling", t, func() {
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				store, err := mstore.Create(context.Background(), fmt.Sprintf("test_redis_pool_%d", i), 10)
+				if err == nil {
+					store.Set("foo", i)
+					err = store.Save()
+				}
+				if err != nil {
+					t.Error(err)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		So(srv.connCount(), ShouldBeLessThanOrEqualTo, 2)
+
+		So(mstore.Close(), ShouldBeNil)
+		_, err := mstore.Check(context.Background(), "test_redis_pool_0")
+		So(err, ShouldEqual, ErrRedisPoolClosed)
+	})
+}
+
+func TestRedisStoreTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// accept connections but never reply
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, conn := range conns {
+				conn.Close()
+			}
+		}()
+
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+
+	mstore := NewRedisStore(ln.Addr().String(), SetRedisStoreTimeout(time.Second*10))
+	defer mstore.Close()
+
+	Convey("Test redis store respects context deadline", t, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+		defer cancel()
+
+		start := time.Now()
+		_, err := mstore.Check(ctx, "test_redis_timeout")
+		So(err, ShouldNotBeNil)
+		So(time.Since(start), ShouldBeLessThan, time.Second)
+	})
+}
@@ -0,0 +1,510 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var _ ManagerStore = &replicatedStore{}
+
+var (
+	ErrNoReplicas     = errors.New("No replicas of the replicated session storage")
+	ErrWriteQuorum    = errors.New("Session write quorum not reached")
+	errReplicaSkipped = errors.New("replica skipped")
+)
+
+// The suffix of the session ids of the replica markers. A marker records the
+// write time (unix nano) of the copy of a session in a replica, or of its
+// deletion (a tombstone), so that reads can tell a deleted session apart from
+// a replica that missed the writes
+const replicaMarkerSuffix = ":replica"
+
+const (
+	replicaMarkerVersionKey = "version"
+	replicaMarkerDeletedKey = "deleted"
+)
+
+// Define default replicated store options
+var defaultReplicatedStoreOptions = replicatedStoreOptions{
+	failureThreshold: 3,
+	retryAfter:       time.Second * 10,
+	markerTTL:        time.Hour * 24,
+}
+
+type replicatedStoreOptions struct {
+	writeQuorum      int
+	failureThreshold int
+	retryAfter       time.Duration
+	markerTTL        time.Duration
+}
+
+type ReplicatedStoreOption func(*replicatedStoreOptions)
+
+// Set the number of replicas that must acknowledge a write
+// (a majority of the replicas by default)
+func SetReplicatedStoreWriteQuorum(writeQuorum int) ReplicatedStoreOption {
+	return func(o *replicatedStoreOptions) {
+		o.writeQuorum = writeQuorum
+	}
+}
+
+// Set the number of consecutive failures after which a replica is skipped
+func SetReplicatedStoreFailureThreshold(threshold int) ReplicatedStoreOption {
+	return func(o *replicatedStoreOptions) {
+		o.failureThreshold = threshold
+	}
+}
+
+// Set how long a failing replica is skipped before it is tried again
+func SetReplicatedStoreRetryAfter(retryAfter time.Duration) ReplicatedStoreOption {
+	return func(o *replicatedStoreOptions) {
+		o.retryAfter = retryAfter
+	}
+}
+
+// Set how long the replica markers are kept (24 hours by default), it should
+// exceed the session expiration so that the stale copies of a deleted session
+// expire before its tombstone
+func SetReplicatedStoreMarkerTTL(ttl time.Duration) ReplicatedStoreOption {
+	return func(o *replicatedStoreOptions) {
+		o.markerTTL = ttl
+	}
+}
+
+// Create a new session storage writing the sessions to all replicas,
+// in order of priority. Each copy and each deletion is stamped with its write
+// time, reads wait for the first replicas - write quorum + 1 answers, use the
+// newest state and write it to the replicas missing it or storing an older
+// one in the background (read-repair)
+func NewReplicatedStore(replicas []ManagerStore, opt ...ReplicatedStoreOption) (ManagerStore, error) {
+	opts := defaultReplicatedStoreOptions
+	for _, o := range opt {
+		o(&opts)
+	}
+
+	if len(replicas) == 0 {
+		return nil, ErrNoReplicas
+	}
+	if opts.writeQuorum <= 0 {
+		opts.writeQuorum = len(replicas)/2 + 1
+	} else if opts.writeQuorum > len(replicas) {
+		opts.writeQuorum = len(replicas)
+	}
+
+	s := &replicatedStore{opts: &opts}
+	for _, store := range replicas {
+		s.replicas = append(s.replicas, &replica{store: store})
+	}
+	return s, nil
+}
+
+// A replica and its health, it is skipped for a while after consecutive failures
+type replica struct {
+	store     ManagerStore
+	mu        sync.Mutex
+	failures  int
+	downUntil time.Time
+}
+
+func (r *replica) available(t time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return !t.Before(r.downUntil)
+}
+
+func (r *replica) record(err error, opts *replicatedStoreOptions) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err == nil {
+		r.failures = 0
+		return
+	}
+
+	r.failures++
+	if opts.failureThreshold > 0 && r.failures >= opts.failureThreshold {
+		r.failures = 0
+		r.downUntil = now().Add(opts.retryAfter)
+	}
+}
+
+type replicatedStore struct {
+	opts     *replicatedStoreOptions
+	replicas []*replica
+	wg       sync.WaitGroup // the reads and repairs outliving the requests
+}
+
+// The state of a session in a replica
+type replicaCopy struct {
+	found   bool  // the replica stores a copy
+	deleted bool  // the replica stores a tombstone
+	version int64 // the write time of the copy or the tombstone
+	values  map[string]interface{}
+}
+
+// order the states by write time, the replicas storing nothing come first
+func (c replicaCopy) rank() int64 {
+	if !c.found && !c.deleted {
+		return -1
+	}
+	return c.version
+}
+
+type replicaResult struct {
+	i    int
+	copy replicaCopy
+	err  error
+}
+
+// a context for the work outliving the request, it keeps the codec of ctx
+func detachContext(ctx context.Context) context.Context {
+	dctx := context.Background()
+	if codec, ok := FromCodecContext(ctx); ok {
+		dctx = newCodecContext(dctx, codec)
+	}
+	return dctx
+}
+
+// Get the available replicas, all replicas if none is available
+func (s *replicatedStore) available() []bool {
+	t := now()
+	available := make([]bool, len(s.replicas))
+	anyAvailable := false
+	for i, r := range s.replicas {
+		available[i] = r.available(t)
+		anyAvailable = anyAvailable || available[i]
+	}
+
+	if !anyAvailable {
+		for i := range available {
+			available[i] = true
+		}
+	}
+	return available
+}
+
+// Call fn for the available replicas concurrently. Skipped replicas get
+// errReplicaSkipped
+func (s *replicatedStore) do(fn func(i int, store ManagerStore) error) []error {
+	available := s.available()
+	errs := make([]error, len(s.replicas))
+	var wg sync.WaitGroup
+	for i, r := range s.replicas {
+		if !available[i] {
+			errs[i] = errReplicaSkipped
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, r *replica) {
+			defer wg.Done()
+			errs[i] = fn(i, r.store)
+			r.record(errs[i], s.opts)
+		}(i, r)
+	}
+	wg.Wait()
+	return errs
+}
+
+// Return nil if n replicas succeeded, or the first replica error
+func replicaQuorum(errs []error, n int) error {
+	successes := 0
+	var err error
+	for _, e := range errs {
+		if e == nil {
+			successes++
+		} else if err == nil && e != errReplicaSkipped {
+			err = e
+		}
+	}
+
+	if successes >= n {
+		return nil
+	} else if err == nil {
+		return ErrWriteQuorum
+	}
+	return err
+}
+
+// A read quorum overlaps each write quorum, it sees the latest write
+func (s *replicatedStore) readQuorum() int {
+	return len(s.replicas) - s.opts.writeQuorum + 1
+}
+
+// Read the session from the available replicas concurrently, fn reads the
+// state of a replica. It returns once the read quorum answered and one of
+// them stores the session, or all replicas answered. Unanswered replicas get
+// errReplicaSkipped, the states of all replicas are passed to late (if set)
+// in the background
+func (s *replicatedStore) read(ctx context.Context, fn func(store ManagerStore) (replicaCopy, error), late func([]replicaCopy, []error)) ([]replicaCopy, []error, error) {
+	available := s.available()
+	copies := make([]replicaCopy, len(s.replicas))
+	errs := make([]error, len(s.replicas))
+	results := make(chan replicaResult, len(s.replicas))
+	pending := 0
+	for i, r := range s.replicas {
+		errs[i] = errReplicaSkipped
+		if !available[i] {
+			continue
+		}
+
+		pending++
+		s.wg.Add(1)
+		go func(i int, r *replica) {
+			defer s.wg.Done()
+			c, err := fn(r.store)
+			r.record(err, s.opts)
+			results <- replicaResult{i: i, copy: c, err: err}
+		}(i, r)
+	}
+
+	successes, newest := 0, int64(-1)
+	for ; pending > 0; pending-- {
+		if successes >= s.readQuorum() && newest >= 0 {
+			break
+		}
+
+		select {
+		case res := <-results:
+			copies[res.i], errs[res.i] = res.copy, res.err
+			if res.err == nil {
+				successes++
+				if rank := res.copy.rank(); rank > newest {
+					newest = rank
+				}
+			}
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+	}
+
+	answered := append([]replicaCopy(nil), copies...)
+	answeredErrs := append([]error(nil), errs...)
+	if late != nil {
+		s.wg.Add(1)
+		go func(pending int) {
+			defer s.wg.Done()
+			for ; pending > 0; pending-- {
+				res := <-results
+				copies[res.i], errs[res.i] = res.copy, res.err
+			}
+			late(copies, errs)
+		}(pending)
+	}
+	return answered, answeredErrs, nil
+}
+
+// Get the replica storing the newest state of the session (the first one
+// if equal), -1 if none stores it
+func newestCopy(copies []replicaCopy, errs []error) int {
+	newest := -1
+	for i, c := range copies {
+		if errs[i] == nil && c.rank() >= 0 && (newest < 0 || c.rank() > copies[newest].rank()) {
+			newest = i
+		}
+	}
+	return newest
+}
+
+func (s *replicatedStore) markerExpired() int64 {
+	return int64(s.opts.markerTTL / time.Second)
+}
+
+// Read the marker of the session in a replica, ok is false if there is none
+func (s *replicatedStore) marker(ctx context.Context, store ManagerStore, sid string) (version int64, deleted, ok bool, err error) {
+	mstore, err := store.Update(ctx, sid+replicaMarkerSuffix, s.markerExpired())
+	if err != nil || isNewStore(mstore) {
+		return 0, false, false, err
+	}
+
+	v, _ := mstore.Get(replicaMarkerVersionKey)
+	d, _ := mstore.Get(replicaMarkerDeletedKey)
+	version, _ = v.(int64)
+	deleted, _ = d.(bool)
+	return version, deleted, true, nil
+}
+
+func (s *replicatedStore) setMarker(ctx context.Context, store ManagerStore, sid string, version int64, deleted bool) error {
+	return saveValues(ctx, store, sid+replicaMarkerSuffix, map[string]interface{}{
+		replicaMarkerVersionKey: version,
+		replicaMarkerDeletedKey: deleted,
+	}, s.markerExpired())
+}
+
+// Read the state of the session in a replica, found reports whether the
+// replica stores a copy. A tombstone hides the copy left by a failed delete
+func (s *replicatedStore) readCopy(ctx context.Context, store ManagerStore, sid string, found bool) (replicaCopy, error) {
+	version, deleted, _, err := s.marker(ctx, store, sid)
+	if err != nil {
+		return replicaCopy{}, err
+	}
+	return replicaCopy{found: found && !deleted, deleted: deleted, version: version}, nil
+}
+
+// Write a copy of the session to a replica, then its marker
+func (s *replicatedStore) write(ctx context.Context, store ManagerStore, sid string, values map[string]interface{}, expired, version int64) error {
+	if err := saveValues(ctx, store, sid, values, expired); err != nil {
+		return err
+	}
+	return s.setMarker(ctx, store, sid, version, false)
+}
+
+// Write the tombstone of the session to a replica, then delete its copy
+func (s *replicatedStore) remove(ctx context.Context, store ManagerStore, sid string, version int64) error {
+	if err := s.setMarker(ctx, store, sid, version, true); err != nil {
+		return err
+	}
+	return store.Delete(ctx, sid)
+}
+
+// Write the newest state of the session to the replicas storing an older
+// one, a tombstone deletes their copies. The marker of a replica is read
+// again before the write, so that a write made since the read is kept
+func (s *replicatedStore) repair(ctx context.Context, sid string, expired int64, copies []replicaCopy, errs []error) {
+	i := newestCopy(copies, errs)
+	if i < 0 {
+		return
+	}
+
+	newest := copies[i]
+	for j, c := range copies {
+		if errs[j] != nil || c.rank() >= newest.rank() || (newest.deleted && !c.found) {
+			continue
+		}
+
+		r := s.replicas[j]
+		r.record(s.repairReplica(ctx, r.store, sid, expired, newest), s.opts)
+	}
+}
+
+func (s *replicatedStore) repairReplica(ctx context.Context, store ManagerStore, sid string, expired int64, newest replicaCopy) error {
+	version, _, ok, err := s.marker(ctx, store, sid)
+	if err != nil || (ok && version >= newest.version) {
+		return err
+	}
+
+	if newest.deleted {
+		return s.remove(ctx, store, sid, newest.version)
+	}
+	return s.write(ctx, store, sid, newest.values, expired, newest.version)
+}
+
+func (s *replicatedStore) save(ctx context.Context, sid string, values map[string]interface{}, expired int64) error {
+	version := now().UnixNano()
+	return replicaQuorum(s.do(func(_ int, store ManagerStore) error {
+		return s.write(ctx, store, sid, values, expired, version)
+	}), s.opts.writeQuorum)
+}
+
+// Read the newest state of the session, the replicas storing an older one
+// are repaired in the background if repair is set
+func (s *replicatedStore) open(ctx context.Context, sid string, expired int64, repair bool) (Store, error) {
+	dctx := detachContext(ctx)
+	var late func([]replicaCopy, []error)
+	if repair {
+		late = func(copies []replicaCopy, errs []error) {
+			s.repair(dctx, sid, expired, copies, errs)
+		}
+	}
+
+	copies, errs, err := s.read(ctx, func(store ManagerStore) (replicaCopy, error) {
+		mstore, err := store.Update(dctx, sid, expired)
+		if err != nil {
+			return replicaCopy{}, err
+		}
+
+		c, err := s.readCopy(dctx, store, sid, !isNewStore(mstore))
+		if err != nil || !c.found {
+			return c, err
+		}
+		if c.values, c.found = storeValues(mstore); !c.found {
+			return c, ErrUnsupportedStore
+		}
+		return c, nil
+	}, late)
+	if err != nil {
+		return nil, err
+	} else if err := replicaQuorum(errs, 1); err != nil {
+		return nil, err
+	}
+
+	i := newestCopy(copies, errs)
+	if i < 0 || copies[i].deleted {
+		return newStore(ctx, s, sid, expired, nil), nil
+	}
+	return newStore(ctx, s, sid, expired, copyValues(copies[i].values)), nil
+}
+
+func (s *replicatedStore) Check(ctx context.Context, sid string) (bool, error) {
+	dctx := detachContext(ctx)
+	copies, errs, err := s.read(ctx, func(store ManagerStore) (replicaCopy, error) {
+		found, err := store.Check(dctx, sid)
+		if err != nil {
+			return replicaCopy{}, err
+		}
+		return s.readCopy(dctx, store, sid, found)
+	}, nil)
+	if err != nil {
+		return false, err
+	} else if err := replicaQuorum(errs, 1); err != nil {
+		return false, err
+	}
+
+	i := newestCopy(copies, errs)
+	return i >= 0 && copies[i].found, nil
+}
+
+func (s *replicatedStore) Create(ctx context.Context, sid string, expired int64) (Store, error) {
+	return newStore(ctx, s, sid, expired, nil), nil
+}
+
+func (s *replicatedStore) Update(ctx context.Context, sid string, expired int64) (Store, error) {
+	return s.open(ctx, sid, expired, true)
+}
+
+// The tombstones keep the copies of the replicas that missed the delete
+// from being read and repaired back
+func (s *replicatedStore) Delete(ctx context.Context, sid string) error {
+	version := now().UnixNano()
+	return replicaQuorum(s.do(func(_ int, store ManagerStore) error {
+		return s.remove(ctx, store, sid, version)
+	}), s.opts.writeQuorum)
+}
+
+// The newest copy of oldsid is written to sid, then oldsid is deleted
+func (s *replicatedStore) Refresh(ctx context.Context, oldsid, sid string, expired int64) (Store, error) {
+	if oldsid == sid {
+		return s.Update(ctx, sid, expired)
+	}
+
+	store, err := s.open(ctx, oldsid, expired, false)
+	if err != nil {
+		return nil, err
+	} else if isNewStore(store) {
+		return newStore(ctx, s, sid, expired, nil), nil
+	}
+
+	values, _ := storeValues(store)
+	if err := s.save(ctx, sid, values, expired); err != nil {
+		return nil, err
+	}
+	if err := s.Delete(ctx, oldsid); err != nil {
+		return nil, err
+	}
+	return newStore(ctx, s, sid, expired, values), nil
+}
+
+// Close waits for the background reads and repairs
+func (s *replicatedStore) Close() error {
+	s.wg.Wait()
+
+	var err error
+	for _, r := range s.replicas {
+		if cerr := r.store.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
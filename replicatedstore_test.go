@@ -0,0 +1,284 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var errTestStore = errors.New("test store error")
+
+// A ManagerStore failing all calls while fail is set
+type failingManagerStore struct {
+	ManagerStore
+	fail  int32
+	calls int32
+}
+
+func (s *failingManagerStore) setFail(fail bool) {
+	var v int32
+	if fail {
+		v = 1
+	}
+	atomic.StoreInt32(&s.fail, v)
+}
+
+func (s *failingManagerStore) err() error {
+	atomic.AddInt32(&s.calls, 1)
+	if atomic.LoadInt32(&s.fail) == 1 {
+		return errTestStore
+	}
+	return nil
+}
+
+func (s *failingManagerStore) Check(ctx context.Context, sid string) (bool, error) {
+	if err := s.err(); err != nil {
+		return false, err
+	}
+	return s.ManagerStore.Check(ctx, sid)
+}
+
+func (s *failingManagerStore) Create(ctx context.Context, sid string, expired int64) (Store, error) {
+	if err := s.err(); err != nil {
+		return nil, err
+	}
+	return s.ManagerStore.Create(ctx, sid, expired)
+}
+
+func (s *failingManagerStore) Update(ctx context.Context, sid string, expired int64) (Store, error) {
+	if err := s.err(); err != nil {
+		return nil, err
+	}
+	return s.ManagerStore.Update(ctx, sid, expired)
+}
+
+func (s *failingManagerStore) Delete(ctx context.Context, sid string) error {
+	if err := s.err(); err != nil {
+		return err
+	}
+	return s.ManagerStore.Delete(ctx, sid)
+}
+
+func (s *failingManagerStore) Refresh(ctx context.Context, oldsid, sid string, expired int64) (Store, error) {
+	if err := s.err(); err != nil {
+		return nil, err
+	}
+	return s.ManagerStore.Refresh(ctx, oldsid, sid, expired)
+}
+
+// A ManagerStore answering the reads after a delay
+type slowManagerStore struct {
+	ManagerStore
+	delay time.Duration
+}
+
+func (s *slowManagerStore) Check(ctx context.Context, sid string) (bool, error) {
+	time.Sleep(s.delay)
+	return s.ManagerStore.Check(ctx, sid)
+}
+
+func (s *slowManagerStore) Update(ctx context.Context, sid string, expired int64) (Store, error) {
+	time.Sleep(s.delay)
+	return s.ManagerStore.Update(ctx, sid, expired)
+}
+
+func TestReplicatedStore(t *testing.T) {
+	Convey("Test replicated store", t, func() {
+		mstore, err := NewReplicatedStore([]ManagerStore{NewMemoryStore(), NewMemoryStore()})
+		So(err, ShouldBeNil)
+		defer mstore.Close()
+		testManagerStore(mstore)
+
+		_, err = NewReplicatedStore(nil)
+		So(err, ShouldEqual, ErrNoReplicas)
+	})
+
+	Convey("Test replicated store write quorum", t, func() {
+		primary := NewMemoryStore()
+		secondary := &failingManagerStore{ManagerStore: NewMemoryStore()}
+		secondary.setFail(true)
+
+		ctx := context.Background()
+		mstore, err := NewReplicatedStore([]ManagerStore{primary, secondary}, SetReplicatedStoreWriteQuorum(2))
+		So(err, ShouldBeNil)
+		store, err := mstore.Create(ctx, "a", 10)
+		So(err, ShouldBeNil)
+		store.Set("foo", "bar")
+		So(store.Save(), ShouldEqual, errTestStore)
+
+		mstore, err = NewReplicatedStore([]ManagerStore{primary, secondary}, SetReplicatedStoreWriteQuorum(1))
+		So(err, ShouldBeNil)
+		So(store.Save(), ShouldEqual, errTestStore)
+
+		store, err = mstore.Create(ctx, "b", 10)
+		So(err, ShouldBeNil)
+		store.Set("foo", "bar")
+		So(store.Save(), ShouldBeNil)
+
+		// read from whichever answers
+		exists, err := mstore.Check(ctx, "b")
+		So(err, ShouldBeNil)
+		So(exists, ShouldBeTrue)
+		store, err = mstore.Update(ctx, "b", 10)
+		So(err, ShouldBeNil)
+		foo, _ := store.Get("foo")
+		So(foo, ShouldEqual, "bar")
+	})
+
+	Convey("Test replicated store read-repair", t, func() {
+		primary := NewMemoryStore()
+		secondary := NewMemoryStore()
+		mstore, err := NewReplicatedStore([]ManagerStore{primary, secondary})
+		So(err, ShouldBeNil)
+		defer mstore.Close()
+
+		ctx := context.Background()
+		saveMemorySession(secondary, "a", map[string]interface{}{"foo": "bar"})
+
+		exists, err := mstore.Check(ctx, "a")
+		So(err, ShouldBeNil)
+		So(exists, ShouldBeTrue)
+
+		store, err := mstore.Update(ctx, "a", 10)
+		So(err, ShouldBeNil)
+		foo, _ := store.Get("foo")
+		So(foo, ShouldEqual, "bar")
+
+		// repaired in the background
+		mstore.(*replicatedStore).wg.Wait()
+		store, err = primary.Update(ctx, "a", 10)
+		So(err, ShouldBeNil)
+		foo, _ = store.Get("foo")
+		So(foo, ShouldEqual, "bar")
+	})
+
+	Convey("Test replicated store reads the newest copy", t, func() {
+		inner := NewMemoryStore()
+		primary := &failingManagerStore{ManagerStore: inner}
+		secondary := NewMemoryStore()
+		mstore, err := NewReplicatedStore([]ManagerStore{primary, secondary}, SetReplicatedStoreWriteQuorum(1))
+		So(err, ShouldBeNil)
+		defer mstore.Close()
+
+		ctx := context.Background()
+		store, err := mstore.Create(ctx, "a", 10)
+		So(err, ShouldBeNil)
+		store.Set("foo", "bar")
+		So(store.Save(), ShouldBeNil)
+
+		// the primary misses the second write
+		time.Sleep(time.Millisecond)
+		primary.setFail(true)
+		store.Set("foo", "baz")
+		So(store.Save(), ShouldBeNil)
+		primary.setFail(false)
+
+		store, err = mstore.Update(ctx, "a", 10)
+		So(err, ShouldBeNil)
+		foo, _ := store.Get("foo")
+		So(foo, ShouldEqual, "baz")
+		values, _ := storeValues(store)
+		So(len(values), ShouldEqual, 1)
+
+		mstore.(*replicatedStore).wg.Wait()
+		store, err = inner.Update(ctx, "a", 10)
+		So(err, ShouldBeNil)
+		foo, _ = store.Get("foo")
+		So(foo, ShouldEqual, "baz")
+	})
+
+	Convey("Test replicated store skips failing replicas", t, func() {
+		primary := NewMemoryStore()
+		secondary := &failingManagerStore{ManagerStore: NewMemoryStore()}
+		mstore, err := NewReplicatedStore(
+			[]ManagerStore{primary, secondary},
+			SetReplicatedStoreWriteQuorum(1),
+			SetReplicatedStoreFailureThreshold(2),
+			SetReplicatedStoreRetryAfter(time.Millisecond*100),
+		)
+		So(err, ShouldBeNil)
+		defer mstore.Close()
+
+		ctx := context.Background()
+		secondary.setFail(true)
+		for i := 0; i < 5; i++ {
+			_, err := mstore.Check(ctx, "a")
+			So(err, ShouldBeNil)
+		}
+		So(atomic.LoadInt32(&secondary.calls), ShouldEqual, 2)
+
+		secondary.setFail(false)
+		time.Sleep(time.Millisecond * 150)
+		_, err = mstore.Check(ctx, "a")
+		So(err, ShouldBeNil)
+		// the copy and its marker
+		So(atomic.LoadInt32(&secondary.calls), ShouldEqual, 4)
+	})
+
+	Convey("Test replicated store does not bring back a deleted session", t, func() {
+		inners := []ManagerStore{NewMemoryStore(), NewMemoryStore(), NewMemoryStore()}
+		third := &failingManagerStore{ManagerStore: inners[2]}
+		mstore, err := NewReplicatedStore([]ManagerStore{inners[0], inners[1], third})
+		So(err, ShouldBeNil)
+		defer mstore.Close()
+
+		ctx := context.Background()
+		store, err := mstore.Create(ctx, "a", 10)
+		So(err, ShouldBeNil)
+		store.Set("user", "alice")
+		So(store.Save(), ShouldBeNil)
+
+		// the third replica misses the logout and keeps its copy
+		time.Sleep(time.Millisecond)
+		third.setFail(true)
+		So(mstore.Delete(ctx, "a"), ShouldBeNil)
+		third.setFail(false)
+
+		exists, err := mstore.Check(ctx, "a")
+		So(err, ShouldBeNil)
+		So(exists, ShouldBeFalse)
+
+		store, err = mstore.Update(ctx, "a", 10)
+		So(err, ShouldBeNil)
+		_, ok := store.Get("user")
+		So(ok, ShouldBeFalse)
+
+		// the stale copy is deleted, not repaired onto the others
+		mstore.(*replicatedStore).wg.Wait()
+		for _, inner := range inners {
+			exists, err := inner.Check(ctx, "a")
+			So(err, ShouldBeNil)
+			So(exists, ShouldBeFalse)
+		}
+		exists, err = mstore.Check(ctx, "a")
+		So(err, ShouldBeNil)
+		So(exists, ShouldBeFalse)
+	})
+
+	Convey("Test replicated store does not wait for a slow replica", t, func() {
+		slow := &slowManagerStore{ManagerStore: NewMemoryStore(), delay: time.Millisecond * 500}
+		mstore, err := NewReplicatedStore([]ManagerStore{NewMemoryStore(), slow})
+		So(err, ShouldBeNil)
+		defer mstore.Close()
+
+		ctx := context.Background()
+		store, err := mstore.Create(ctx, "a", 10)
+		So(err, ShouldBeNil)
+		store.Set("foo", "bar")
+		So(store.Save(), ShouldBeNil)
+
+		start := time.Now()
+		exists, err := mstore.Check(ctx, "a")
+		So(err, ShouldBeNil)
+		So(exists, ShouldBeTrue)
+		store, err = mstore.Update(ctx, "a", 10)
+		So(err, ShouldBeNil)
+		foo, _ := store.Get("foo")
+		So(foo, ShouldEqual, "bar")
+		So(time.Since(start), ShouldBeLessThan, time.Millisecond*250)
+	})
+}
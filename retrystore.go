@@ -0,0 +1,249 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	_ ManagerStore = &retryStore{}
+	_ Locker       = &retryStore{}
+)
+
+// Report whether a failed store call may succeed when it is retried
+type RetryableFunc func(err error) bool
+
+// The default RetryableFunc, only the errors known to be transient are
+// retried: network errors, connections closed by the server and the
+// redis errors asking the client to try again
+func DefaultRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var rerr redisError
+	if errors.As(err, &rerr) {
+		for _, prefix := range []string{"LOADING", "TRYAGAIN", "BUSY", "CLUSTERDOWN", "MASTERDOWN"} {
+			if strings.HasPrefix(string(rerr), prefix) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var nerr net.Error
+	return errors.As(err, &nerr) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE)
+}
+
+// Define default retry store options
+var defaultRetryStoreOptions = retryStoreOptions{
+	maxAttempts:    3,
+	initialBackoff: time.Millisecond * 50,
+	maxBackoff:     time.Second * 2,
+	multiplier:     2,
+	jitter:         0.5,
+	retryable:      DefaultRetryable,
+}
+
+type retryStoreOptions struct {
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	multiplier     float64
+	jitter         float64
+	retryable      RetryableFunc
+	retryRefresh   bool
+}
+
+type RetryStoreOption func(*retryStoreOptions)
+
+// Set the maximum number of attempts of a call, including the first one
+func SetRetryMaxAttempts(maxAttempts int) RetryStoreOption {
+	return func(o *retryStoreOptions) {
+		o.maxAttempts = maxAttempts
+	}
+}
+
+// Set the backoff before the first retry and the maximum backoff
+func SetRetryBackoff(initial, max time.Duration) RetryStoreOption {
+	return func(o *retryStoreOptions) {
+		o.initialBackoff = initial
+		o.maxBackoff = max
+	}
+}
+
+// Set the factor applied to the backoff after each retry
+func SetRetryMultiplier(multiplier float64) RetryStoreOption {
+	return func(o *retryStoreOptions) {
+		o.multiplier = multiplier
+	}
+}
+
+// Set the fraction of the backoff that is randomized (0 to 1)
+func SetRetryJitter(jitter float64) RetryStoreOption {
+	return func(o *retryStoreOptions) {
+		o.jitter = jitter
+	}
+}
+
+// Set the function reporting whether an error is retried
+func SetRetryable(retryable RetryableFunc) RetryStoreOption {
+	return func(o *retryStoreOptions) {
+		o.retryable = retryable
+	}
+}
+
+// Retry Refresh too (disabled by default), Refresh of store must be safe
+// to repeat after a failure, e.g. when the session was already moved
+func SetRetryRefresh(retryRefresh bool) RetryStoreOption {
+	return func(o *retryStoreOptions) {
+		o.retryRefresh = retryRefresh
+	}
+}
+
+// Create a new session storage retrying the failed calls of store and
+// the failed saves of its sessions with exponential backoff
+func NewRetryStore(store ManagerStore, opt ...RetryStoreOption) ManagerStore {
+	opts := defaultRetryStoreOptions
+	for _, o := range opt {
+		o(&opts)
+	}
+
+	return &retryStore{
+		opts:  &opts,
+		store: store,
+	}
+}
+
+type retryStore struct {
+	opts  *retryStoreOptions
+	store ManagerStore
+}
+
+// Get the backoff before the retry following attempt (from 1),
+// r is a random number in [0, 1)
+func (o *retryStoreOptions) backoff(attempt int, r float64) time.Duration {
+	d := float64(o.initialBackoff)
+	for i := 1; i < attempt && d < float64(o.maxBackoff); i++ {
+		d *= o.multiplier
+	}
+	if o.maxBackoff > 0 && d > float64(o.maxBackoff) {
+		d = float64(o.maxBackoff)
+	}
+	return time.Duration(d * (1 - o.jitter*r))
+}
+
+// Call fn until it succeeds, fails with an error that is not retryable,
+// runs out of attempts or ctx is done
+func (s *retryStore) retry(ctx context.Context, fn func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil || attempt >= s.opts.maxAttempts || !s.opts.retryable(err) {
+			return err
+		}
+
+		timer := time.NewTimer(s.opts.backoff(attempt, rand.Float64()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Read a session with fn, retried if retry is set, into a session store
+// of the retry store so that its saves are retried as well
+func (s *retryStore) open(ctx context.Context, sid string, expired int64, retry bool, fn func() (Store, error)) (Store, error) {
+	var values map[string]interface{}
+	read := func() error {
+		store, err := fn()
+		if err != nil || isNewStore(store) {
+			return err
+		}
+
+		v, ok := storeValues(store)
+		if !ok {
+			return ErrUnsupportedStore
+		}
+		values = v
+		return nil
+	}
+
+	var err error
+	if retry {
+		err = s.retry(ctx, read)
+	} else {
+		err = read()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newStore(ctx, s, sid, expired, values), nil
+}
+
+func (s *retryStore) save(ctx context.Context, sid string, values map[string]interface{}, expired int64) error {
+	return s.retry(ctx, func() error {
+		return saveValues(ctx, s.store, sid, values, expired)
+	})
+}
+
+func (s *retryStore) Check(ctx context.Context, sid string) (bool, error) {
+	var exists bool
+	err := s.retry(ctx, func() error {
+		var err error
+		exists, err = s.store.Check(ctx, sid)
+		return err
+	})
+	return exists, err
+}
+
+func (s *retryStore) Create(ctx context.Context, sid string, expired int64) (Store, error) {
+	return newStore(ctx, s, sid, expired, nil), nil
+}
+
+func (s *retryStore) Update(ctx context.Context, sid string, expired int64) (Store, error) {
+	return s.open(ctx, sid, expired, true, func() (Store, error) {
+		return s.store.Update(ctx, sid, expired)
+	})
+}
+
+func (s *retryStore) Delete(ctx context.Context, sid string) error {
+	return s.retry(ctx, func() error {
+		return s.store.Delete(ctx, sid)
+	})
+}
+
+func (s *retryStore) Refresh(ctx context.Context, oldsid, sid string, expired int64) (Store, error) {
+	return s.open(ctx, sid, expired, s.opts.retryRefresh, func() (Store, error) {
+		return s.store.Refresh(ctx, oldsid, sid, expired)
+	})
+}
+
+// The session locks are held in the wrapped store
+func (s *retryStore) Lock(ctx context.Context, sid string, ttl time.Duration) (string, error) {
+	return wrappedLocker(s.store).Lock(ctx, sid, ttl)
+}
+
+func (s *retryStore) Renew(ctx context.Context, sid, token string, ttl time.Duration) error {
+	return wrappedLocker(s.store).Renew(ctx, sid, token, ttl)
+}
+
+func (s *retryStore) Unlock(ctx context.Context, sid, token string) error {
+	return wrappedLocker(s.store).Unlock(ctx, sid, token)
+}
+
+func (s *retryStore) Close() error {
+	return s.store.Close()
+}
@@ -0,0 +1,155 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// A ManagerStore failing the first updates and saves with a transient error
+type flakyManagerStore struct {
+	ManagerStore
+	failures     int32
+	saveFailures int32
+	saves        int32
+}
+
+func (s *flakyManagerStore) SaveValues(ctx context.Context, sid string, values map[string]interface{}, expired int64) error {
+	atomic.AddInt32(&s.saves, 1)
+	if atomic.AddInt32(&s.saveFailures, -1) >= 0 {
+		return io.ErrUnexpectedEOF
+	}
+	return saveValues(ctx, s.ManagerStore, sid, values, expired)
+}
+
+func (s *flakyManagerStore) Update(ctx context.Context, sid string, expired int64) (Store, error) {
+	if atomic.AddInt32(&s.failures, -1) >= 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return s.ManagerStore.Update(ctx, sid, expired)
+}
+
+func TestRetryStore(t *testing.T) {
+	Convey("Test retry store", t, func() {
+		mstore := NewRetryStore(NewMemoryStore())
+		defer mstore.Close()
+		testManagerStore(mstore)
+	})
+
+	Convey("Test retry store backoff", t, func() {
+		opts := defaultRetryStoreOptions
+		SetRetryBackoff(time.Millisecond*100, time.Second)(&opts)
+		So(opts.backoff(1, 0), ShouldEqual, time.Millisecond*100)
+		So(opts.backoff(2, 0), ShouldEqual, time.Millisecond*200)
+		So(opts.backoff(3, 0), ShouldEqual, time.Millisecond*400)
+		So(opts.backoff(10, 0), ShouldEqual, time.Second)
+		So(opts.backoff(2, 0.5), ShouldEqual, time.Millisecond*150)
+	})
+
+	Convey("Test retry store retries transient errors", t, func() {
+		mstore := NewRetryStore(&flakyManagerStore{ManagerStore: NewMemoryStore(), failures: 2},
+			SetRetryBackoff(time.Millisecond, time.Millisecond*10),
+		)
+		defer mstore.Close()
+
+		saveMemorySession(mstore, "a", map[string]interface{}{"foo": "bar"})
+		store, err := mstore.Update(context.Background(), "a", 10)
+		So(err, ShouldBeNil)
+		foo, _ := store.Get("foo")
+		So(foo, ShouldEqual, "bar")
+	})
+
+	Convey("Test retry store retries the saves of its sessions", t, func() {
+		inner := NewMemoryStore()
+		defer inner.Close()
+		flaky := &flakyManagerStore{ManagerStore: inner, saveFailures: 1}
+		mstore := NewRetryStore(flaky, SetRetryBackoff(time.Millisecond, time.Millisecond*10))
+
+		ctx := context.Background()
+		store, err := mstore.Create(ctx, "a", 10)
+		So(err, ShouldBeNil)
+		store.Set("foo", "bar")
+		So(store.Save(), ShouldBeNil)
+		So(atomic.LoadInt32(&flaky.saves), ShouldEqual, 2)
+
+		store, err = inner.Update(ctx, "a", 10)
+		So(err, ShouldBeNil)
+		foo, _ := store.Get("foo")
+		So(foo, ShouldEqual, "bar")
+	})
+
+	Convey("Test retry store gives up", t, func() {
+		ctx := context.Background()
+		inner := &failingManagerStore{ManagerStore: NewMemoryStore()}
+		inner.setFail(true)
+		defer inner.Close()
+
+		mstore := NewRetryStore(inner,
+			SetRetryMaxAttempts(4),
+			SetRetryBackoff(time.Millisecond, time.Millisecond*10),
+			SetRetryable(func(err error) bool {
+				return errors.Is(err, errTestStore)
+			}),
+		)
+		_, err := mstore.Check(ctx, "a")
+		So(err, ShouldEqual, errTestStore)
+		So(atomic.LoadInt32(&inner.calls), ShouldEqual, 4)
+
+		// not known to be transient
+		mstore = NewRetryStore(inner)
+		So(mstore.Delete(ctx, "a"), ShouldEqual, errTestStore)
+		So(atomic.LoadInt32(&inner.calls), ShouldEqual, 5)
+
+		// not retried by default
+		mstore = NewRetryStore(inner, SetRetryable(func(err error) bool {
+			return true
+		}))
+		_, err = mstore.Refresh(ctx, "a", "b", 10)
+		So(err, ShouldEqual, errTestStore)
+		So(atomic.LoadInt32(&inner.calls), ShouldEqual, 6)
+	})
+
+	Convey("Test retry store default retryable errors", t, func() {
+		So(DefaultRetryable(io.EOF), ShouldBeTrue)
+		So(DefaultRetryable(fmt.Errorf("read: %w", syscall.ECONNRESET)), ShouldBeTrue)
+		So(DefaultRetryable(&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}), ShouldBeTrue)
+		So(DefaultRetryable(redisError("LOADING Redis is loading the dataset in memory")), ShouldBeTrue)
+
+		So(DefaultRetryable(redisError("WRONGTYPE Operation against a key holding the wrong kind of value")), ShouldBeFalse)
+		So(DefaultRetryable(context.DeadlineExceeded), ShouldBeFalse)
+		So(DefaultRetryable(ErrConflict), ShouldBeFalse)
+		So(DefaultRetryable(ErrCookieTooLarge), ShouldBeFalse)
+		So(DefaultRetryable(ErrWriteQuorum), ShouldBeFalse)
+		So(DefaultRetryable(errTestStore), ShouldBeFalse)
+	})
+
+	Convey("Test retry store stops when the context is done", t, func() {
+		inner := &failingManagerStore{ManagerStore: NewMemoryStore()}
+		inner.setFail(true)
+		mstore := NewRetryStore(inner,
+			SetRetryMaxAttempts(10),
+			SetRetryBackoff(time.Second, time.Second),
+			SetRetryable(func(err error) bool {
+				return true
+			}),
+			SetRetryRefresh(true),
+		)
+		defer mstore.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+		defer cancel()
+		start := time.Now()
+		_, err := mstore.Refresh(ctx, "a", "b", 10)
+		So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+		So(time.Since(start), ShouldBeLessThan, time.Second)
+		So(atomic.LoadInt32(&inner.calls), ShouldEqual, 1)
+	})
+}
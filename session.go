@@ -7,6 +7,7 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"log"
 	"net"
 	"net/http"
 	"net/url"
@@ -24,24 +25,41 @@ var (
 // Define the handler to get the session id
 type IDHandlerFunc func(context.Context) string
 
+// Define the handler of the errors raised by the middleware
+type ErrorHandlerFunc func(http.ResponseWriter, *http.Request, error)
+
+// Define the handler of the errors raised by the middleware
+// after the response header was sent
+type LateErrorHandlerFunc func(*http.Request, error)
+
 // Define default options
 var defaultOptions = options{
-	cookieName:     "go_session_id",
-	cookieLifeTime: 3600 * 24 * 7,
-	expired:        7200,
-	secure:         true,
-	sameSite:       http.SameSiteDefaultMode,
+	cookieName:      "go_session_id",
+	cookieLifeTime:  3600 * 24 * 7,
+	cookieChunkSize: defaultCookieChunkSize,
+	expired:         7200,
+	secure:          true,
+	sameSite:        http.SameSiteDefaultMode,
 	sessionID: func(_ context.Context) string {
 		return newUUID()
 	},
 	enableSetCookie:     true,
 	enableSIDInURLQuery: true,
+	conflictRetries:     3,
+	lockTTL:             time.Second * 30,
+	errorHandler: func(w http.ResponseWriter, _ *http.Request, _ error) {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	},
+	lateErrorHandler: func(r *http.Request, err error) {
+		log.Printf("session: save after the response of %s %s: %v", r.Method, r.URL.Path, err)
+	},
 }
 
 type options struct {
 	sign                    []byte
 	cookieName              string
 	cookieLifeTime          int
+	cookieChunkSize         int
 	secure                  bool
 	domain                  string
 	sameSite                http.SameSite
@@ -52,6 +70,12 @@ type options struct {
 	enableSIDInHTTPHeader   bool
 	sessionNameInHTTPHeader string
 	store                   ManagerStore
+	codec                   Codec
+	errorHandler            ErrorHandlerFunc
+	lateErrorHandler        LateErrorHandlerFunc
+	conflictRetries         int
+	lockPredicate           LockPredicateFunc
+	lockTTL                 time.Duration
 }
 
 type Option func(*options)
@@ -77,6 +101,14 @@ func SetCookieLifeTime(cookieLifeTime int) Option {
 	}
 }
 
+// Set the maximum size of the cookie value, larger values are split
+// across name_0, name_1, ... cookies (0 disables splitting)
+func SetCookieChunkSize(cookieChunkSize int) Option {
+	return func(o *options) {
+		o.cookieChunkSize = cookieChunkSize
+	}
+}
+
 // Set the domain name of the cookie
 func SetDomain(domain string) Option {
 	return func(o *options) {
@@ -149,6 +181,52 @@ func SetStore(store ManagerStore) Option {
 	}
 }
 
+// Set the codec used by the stores to serialize session values
+func SetCodec(codec Codec) Option {
+	return func(o *options) {
+		o.codec = codec
+	}
+}
+
+// Set the handler of the store errors raised by the middleware
+// (responds with 500 Internal Server Error by default)
+func SetErrorHandler(handler ErrorHandlerFunc) Option {
+	return func(o *options) {
+		o.errorHandler = handler
+	}
+}
+
+// Set the handler of the store errors raised by the middleware after the
+// response header was sent, when the response can not be changed anymore
+// (logs the error by default)
+func SetLateErrorHandler(handler LateErrorHandlerFunc) Option {
+	return func(o *options) {
+		o.lateErrorHandler = handler
+	}
+}
+
+// Set the number of times Update retries the session mutation
+// when the session was modified concurrently (3 by default),
+// only the versioned storages (see VersionStore) report such conflicts
+func SetConflictRetries(conflictRetries int) Option {
+	return func(o *options) {
+		o.conflictRetries = conflictRetries
+	}
+}
+
+// Hold the session lock between Start and Save for the requests matching
+// the predicate, Start fails with ErrLockNotSupported for these requests if
+// the store does not implement Locker. The lock lease of ttl
+// (30 seconds by default) is renewed until the session is saved or the request is done
+func SetSessionLock(predicate LockPredicateFunc, ttl time.Duration) Option {
+	return func(o *options) {
+		o.lockPredicate = predicate
+		if ttl > 0 {
+			o.lockTTL = ttl
+		}
+	}
+}
+
 // Create a session management instance
 func NewManager(opt ...Option) *Manager {
 	opts := defaultOptions
@@ -177,6 +255,9 @@ func (m *Manager) getContext(ctx context.Context, w http.ResponseWriter, r *http
 	}
 	ctx = newReqContext(ctx, r)
 	ctx = newResContext(ctx, w)
+	if m.opts.codec != nil {
+		ctx = newCodecContext(ctx, m.opts.codec)
+	}
 	return ctx
 }
 
@@ -214,10 +295,7 @@ func (m *Manager) sessionID(r *http.Request) (string, error) {
 	var cookieValue string
 
 	if m.opts.enableSetCookie {
-		cookie, err := r.Cookie(m.opts.cookieName)
-		if err == nil && cookie.Value != "" {
-			cookieValue = cookie.Value
-		}
+		cookieValue = readCookie(r, m.opts.cookieName)
 	}
 
 	if m.opts.enableSIDInURLQuery && cookieValue == "" {
@@ -282,8 +360,11 @@ func (m *Manager) setCookie(sessionID string, w http.ResponseWriter, r *http.Req
 			cookie.Expires = time.Now().Add(time.Duration(v) * time.Second)
 		}
 
-		http.SetCookie(w, cookie)
-		r.AddCookie(cookie)
+		cookies := splitCookie(cookie, m.opts.cookieChunkSize)
+		setCookieChunks(w, r, cookie, cookies)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
 	}
 
 	if m.opts.enableSIDInHTTPHeader {
@@ -303,6 +384,12 @@ func (m *Manager) Start(ctx context.Context, w http.ResponseWriter, r *http.Requ
 	}
 
 	if sid != "" {
+		if locker, err := m.locker(r); err != nil {
+			return nil, err
+		} else if locker != nil {
+			return m.startLocked(ctx, w, r, locker, sid)
+		}
+
 		if exists, err := m.opts.store.Check(ctx, sid); err != nil {
 			return nil, err
 		} else if exists {
@@ -310,7 +397,52 @@ func (m *Manager) Start(ctx context.Context, w http.ResponseWriter, r *http.Requ
 		}
 	}
 
-	sid = m.opts.sessionID(ctx)
+	return m.create(ctx, w, r)
+}
+
+// Get the locker of the store if the request must hold the session lock,
+// fails with ErrLockNotSupported if the store does not implement Locker
+func (m *Manager) locker(r *http.Request) (Locker, error) {
+	if m.opts.lockPredicate == nil || !m.opts.lockPredicate(r) {
+		return nil, nil
+	}
+	locker, ok := m.opts.store.(Locker)
+	if !ok {
+		return nil, ErrLockNotSupported
+	}
+	return locker, nil
+}
+
+// Lock the session before reading it, the lock is released by Save
+// or when the request context is done
+func (m *Manager) startLocked(ctx context.Context, w http.ResponseWriter, r *http.Request, locker Locker, sid string) (Store, error) {
+	token, err := locker.Lock(ctx, sid, m.opts.lockTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	exists, err := m.opts.store.Check(ctx, sid)
+	if err != nil || !exists {
+		_ = locker.Unlock(ctx, sid, token)
+		if err != nil {
+			return nil, err
+		}
+		return m.create(ctx, w, r)
+	}
+
+	store, err := m.opts.store.Update(ctx, sid, m.opts.expired)
+	if err != nil {
+		_ = locker.Unlock(ctx, sid, token)
+		return nil, err
+	}
+	// bound to the request rather than ctx, so that the lock of a request
+	// that does not save the session is released when it is done
+	return newLockedStore(r.Context(), store, locker, token, m.opts.lockTTL), nil
+}
+
+// Create a new session and write its id to the response
+func (m *Manager) create(ctx context.Context, w http.ResponseWriter, r *http.Request) (Store, error) {
+	sid := m.opts.sessionID(ctx)
 	store, err := m.opts.store.Create(ctx, sid, m.opts.expired)
 	if err != nil {
 		return nil, err
@@ -341,6 +473,29 @@ func (m *Manager) Refresh(ctx context.Context, w http.ResponseWriter, r *http.Re
 	return store, nil
 }
 
+// Start a session, apply fn to the session store and save it,
+// fn is called again with the latest session values when the save fails with ErrConflict.
+// Conflicts are only detected by the versioned storages (see VersionStore),
+// with the other storages the last save wins
+func (m *Manager) Update(ctx context.Context, w http.ResponseWriter, r *http.Request, fn func(Store) error) error {
+	for i := 0; ; i++ {
+		store, err := m.Start(ctx, w, r)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(store); err != nil {
+			releaseLock(store)
+			return err
+		}
+
+		err = store.Save()
+		if err != ErrConflict || i >= m.opts.conflictRetries {
+			return err
+		}
+	}
+}
+
 // Destroy a session
 func (m *Manager) Destroy(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 	ctx = m.getContext(ctx, w, r)
@@ -368,11 +523,10 @@ func (m *Manager) Destroy(ctx context.Context, w http.ResponseWriter, r *http.Re
 			Name:     m.opts.cookieName,
 			Path:     "/",
 			HttpOnly: true,
-			Expires:  time.Now(),
-			MaxAge:   -1,
+			Domain:   m.opts.domain,
 		}
 
-		http.SetCookie(w, cookie)
+		deleteCookie(w, r, cookie)
 	}
 
 	if m.opts.enableSIDInHTTPHeader {
@@ -1,6 +1,7 @@
 package session
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
@@ -200,3 +201,61 @@ func TestSessionRefresh(t *testing.T) {
 		So(string(buf), ShouldEqual, "bar:true")
 	})
 }
+
+func TestSessionUpdate(t *testing.T) {
+	manager := NewManager(
+		SetCookieName("test_session_update"),
+		SetConflictRetries(1),
+	)
+
+	Convey("Test session update retries on conflict", t, func() {
+		ctx := context.Background()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		err := manager.Update(ctx, w, r, func(store Store) error {
+			store.Set("count", 0)
+			return nil
+		})
+		So(err, ShouldBeNil)
+
+		calls := 0
+		err = manager.Update(ctx, w, r, func(store Store) error {
+			calls++
+			if calls == 1 {
+				// a concurrent request writes the count first
+				other, err := manager.Start(ctx, httptest.NewRecorder(), r)
+				So(err, ShouldBeNil)
+				other.Set("count", 10)
+				other.Set("other", true)
+				So(other.Save(), ShouldBeNil)
+			}
+
+			count, _ := store.Get("count")
+			store.Set("count", count.(int)+1)
+			return nil
+		})
+		So(err, ShouldBeNil)
+		So(calls, ShouldEqual, 2)
+
+		store, err := manager.Start(ctx, w, r)
+		So(err, ShouldBeNil)
+		count, _ := store.Get("count")
+		So(count, ShouldEqual, 11)
+		other, _ := store.Get("other")
+		So(other, ShouldEqual, true)
+
+		calls = 0
+		err = manager.Update(ctx, w, r, func(store Store) error {
+			calls++
+			other, err := manager.Start(ctx, httptest.NewRecorder(), r)
+			So(err, ShouldBeNil)
+			other.Set("count", 0)
+			So(other.Save(), ShouldBeNil)
+
+			store.Set("count", 2)
+			return nil
+		})
+		So(err, ShouldEqual, ErrConflict)
+		So(calls, ShouldEqual, 2)
+	})
+}
@@ -0,0 +1,252 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	_ ShardedStore = &shardedStore{}
+	_ Locker       = &shardedStore{}
+)
+
+var (
+	ErrNoShards         = errors.New("No shards of the sharded session storage")
+	ErrDuplicateShard   = errors.New("Duplicate shard name")
+	ErrUnsupportedStore = errors.New("The session store does not support reading all values")
+)
+
+// A child storage of a sharded storage, the name places it on the hash ring
+// and must not change when the storage is recreated
+type Shard struct {
+	Name  string
+	Store ManagerStore
+}
+
+// A session storage distributing the sessions over shards
+type ShardedStore interface {
+	ManagerStore
+	// Add a shard, the sessions it takes over from the other shards
+	// are moved to it when they are used
+	AddShard(shard Shard) error
+}
+
+// Define default sharded store options
+var defaultShardedStoreOptions = shardedStoreOptions{
+	replicas: 100,
+}
+
+type shardedStoreOptions struct {
+	replicas int
+}
+
+type ShardedStoreOption func(*shardedStoreOptions)
+
+// Set the number of virtual nodes of each shard on the hash ring
+func SetShardedStoreReplicas(replicas int) ShardedStoreOption {
+	return func(o *shardedStoreOptions) {
+		o.replicas = replicas
+	}
+}
+
+// Create a new session storage routing each session to one of the shards
+// by consistent hashing of the session id
+func NewShardedStore(shards []Shard, opt ...ShardedStoreOption) (ShardedStore, error) {
+	opts := defaultShardedStoreOptions
+	for _, o := range opt {
+		o(&opts)
+	}
+
+	if len(shards) == 0 {
+		return nil, ErrNoShards
+	}
+
+	s := &shardedStore{opts: &opts}
+	for _, shard := range shards {
+		if err := s.add(shard); err != nil {
+			return nil, err
+		}
+	}
+	s.rings = []*hashRing{s.newRing()}
+	return s, nil
+}
+
+type shardedStore struct {
+	opts   *shardedStoreOptions
+	mu     sync.RWMutex
+	shards []Shard
+	// the current ring first, followed by the rings before shards were added
+	rings []*hashRing
+}
+
+// s.mu must be held
+func (s *shardedStore) add(shard Shard) error {
+	for _, sh := range s.shards {
+		if sh.Name == shard.Name {
+			return ErrDuplicateShard
+		}
+	}
+	s.shards = append(s.shards, shard)
+	return nil
+}
+
+// s.mu must be held
+func (s *shardedStore) newRing() *hashRing {
+	names := make([]string, len(s.shards))
+	for i, shard := range s.shards {
+		names[i] = shard.Name
+	}
+	return newHashRing(names, s.opts.replicas)
+}
+
+func (s *shardedStore) AddShard(shard Shard) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.add(shard); err != nil {
+		return err
+	}
+	s.rings = append([]*hashRing{s.newRing()}, s.rings...)
+	return nil
+}
+
+// Get the shard owning sid and the shards that owned it before shards were added
+func (s *shardedStore) owners(sid string) (ManagerStore, []ManagerStore) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	owner := s.rings[0].get(sid)
+	var previous []ManagerStore
+	seen := map[int]bool{owner: true}
+	for _, ring := range s.rings[1:] {
+		if i := ring.get(sid); !seen[i] {
+			seen[i] = true
+			previous = append(previous, s.shards[i].Store)
+		}
+	}
+	return s.shards[owner].Store, previous
+}
+
+// Find the shard storing sid, the current owner if the session does not exist
+func (s *shardedStore) locate(ctx context.Context, sid string) (ManagerStore, error) {
+	owner, previous := s.owners(sid)
+	if len(previous) == 0 {
+		return owner, nil
+	}
+
+	if exists, err := owner.Check(ctx, sid); err != nil || exists {
+		return owner, err
+	}
+	for _, shard := range previous {
+		if exists, err := shard.Check(ctx, sid); err != nil {
+			return nil, err
+		} else if exists {
+			return shard, nil
+		}
+	}
+	return owner, nil
+}
+
+// Move the session sid of from to the shard storing newsid
+func (s *shardedStore) move(ctx context.Context, from ManagerStore, sid, newsid string, expired int64) (Store, error) {
+	to, _ := s.owners(newsid)
+
+	store, err := from.Update(ctx, sid, expired)
+	if err != nil {
+		return nil, err
+	} else if isNewStore(store) {
+		return to.Create(ctx, newsid, expired)
+	}
+
+	values, ok := storeValues(store)
+	if !ok {
+		return nil, ErrUnsupportedStore
+	}
+	if err := saveValues(ctx, to, newsid, values, expired); err != nil {
+		return nil, err
+	}
+	if err := from.Delete(ctx, sid); err != nil {
+		return nil, err
+	}
+	return to.Update(ctx, newsid, expired)
+}
+
+func (s *shardedStore) Check(ctx context.Context, sid string) (bool, error) {
+	shard, err := s.locate(ctx, sid)
+	if err != nil {
+		return false, err
+	}
+	return shard.Check(ctx, sid)
+}
+
+func (s *shardedStore) Create(ctx context.Context, sid string, expired int64) (Store, error) {
+	owner, _ := s.owners(sid)
+	return owner.Create(ctx, sid, expired)
+}
+
+// A session stored by a previous owner is moved to its current owner
+func (s *shardedStore) Update(ctx context.Context, sid string, expired int64) (Store, error) {
+	shard, err := s.locate(ctx, sid)
+	if err != nil {
+		return nil, err
+	}
+
+	if owner, _ := s.owners(sid); shard != owner {
+		return s.move(ctx, shard, sid, sid, expired)
+	}
+	return shard.Update(ctx, sid, expired)
+}
+
+func (s *shardedStore) Delete(ctx context.Context, sid string) error {
+	owner, previous := s.owners(sid)
+	for _, shard := range append([]ManagerStore{owner}, previous...) {
+		if err := shard.Delete(ctx, sid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The session is moved when sid is owned by another shard than oldsid
+func (s *shardedStore) Refresh(ctx context.Context, oldsid, sid string, expired int64) (Store, error) {
+	shard, err := s.locate(ctx, oldsid)
+	if err != nil {
+		return nil, err
+	}
+
+	if owner, _ := s.owners(sid); shard != owner {
+		return s.move(ctx, shard, oldsid, sid, expired)
+	}
+	return shard.Refresh(ctx, oldsid, sid, expired)
+}
+
+// The session locks are held in the shard owning the session
+func (s *shardedStore) Lock(ctx context.Context, sid string, ttl time.Duration) (string, error) {
+	owner, _ := s.owners(sid)
+	return wrappedLocker(owner).Lock(ctx, sid, ttl)
+}
+
+func (s *shardedStore) Renew(ctx context.Context, sid, token string, ttl time.Duration) error {
+	owner, _ := s.owners(sid)
+	return wrappedLocker(owner).Renew(ctx, sid, token, ttl)
+}
+
+func (s *shardedStore) Unlock(ctx context.Context, sid, token string) error {
+	owner, _ := s.owners(sid)
+	return wrappedLocker(owner).Unlock(ctx, sid, token)
+}
+
+func (s *shardedStore) Close() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var err error
+	for _, shard := range s.shards {
+		if cerr := shard.Store.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,123 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func newTestShards(names ...string) []Shard {
+	shards := make([]Shard, len(names))
+	for i, name := range names {
+		shards[i] = Shard{Name: name, Store: NewMemoryStore()}
+	}
+	return shards
+}
+
+func shardSessions(shard Shard) int {
+	return shard.Store.(MemoryStatsStore).Stats().Sessions
+}
+
+func TestShardedStore(t *testing.T) {
+	Convey("Test sharded store", t, func() {
+		mstore, err := NewShardedStore(newTestShards("a", "b", "c"))
+		So(err, ShouldBeNil)
+		defer mstore.Close()
+		testManagerStore(mstore)
+
+		_, err = NewShardedStore(nil)
+		So(err, ShouldEqual, ErrNoShards)
+		_, err = NewShardedStore(newTestShards("a", "a"))
+		So(err, ShouldEqual, ErrDuplicateShard)
+		So(mstore.AddShard(Shard{Name: "a", Store: NewMemoryStore()}), ShouldEqual, ErrDuplicateShard)
+	})
+
+	Convey("Test sharded store distributes the sessions", t, func() {
+		shards := newTestShards("a", "b", "c")
+		mstore, err := NewShardedStore(shards)
+		So(err, ShouldBeNil)
+		defer mstore.Close()
+
+		for i := 0; i < 300; i++ {
+			saveMemorySession(mstore, fmt.Sprintf("sid_%d", i), map[string]interface{}{"foo": i})
+		}
+
+		total := 0
+		for _, shard := range shards {
+			So(shardSessions(shard), ShouldBeGreaterThan, 50)
+			total += shardSessions(shard)
+		}
+		So(total, ShouldEqual, 300)
+	})
+
+	Convey("Test sharded store moves refreshed sessions between shards", t, func() {
+		shards := newTestShards("a", "b", "c")
+		mstore, err := NewShardedStore(shards)
+		So(err, ShouldBeNil)
+		defer mstore.Close()
+
+		ctx := context.Background()
+		s := mstore.(*shardedStore)
+		for i := 0; i < 20; i++ {
+			oldsid, sid := fmt.Sprintf("old_%d", i), fmt.Sprintf("new_%d", i)
+			saveMemorySession(mstore, oldsid, map[string]interface{}{"foo": i})
+
+			store, err := mstore.Refresh(ctx, oldsid, sid, 10)
+			So(err, ShouldBeNil)
+			foo, _ := store.Get("foo")
+			So(foo, ShouldEqual, i)
+
+			from, _ := s.owners(oldsid)
+			exists, err := from.Check(ctx, oldsid)
+			So(err, ShouldBeNil)
+			So(exists, ShouldBeFalse)
+
+			to, _ := s.owners(sid)
+			exists, err = to.Check(ctx, sid)
+			So(err, ShouldBeNil)
+			So(exists, ShouldBeTrue)
+		}
+	})
+
+	Convey("Test sharded store migrates sessions to added shards lazily", t, func() {
+		shards := newTestShards("a", "b")
+		mstore, err := NewShardedStore(shards)
+		So(err, ShouldBeNil)
+		defer mstore.Close()
+
+		ctx := context.Background()
+		for i := 0; i < 100; i++ {
+			saveMemorySession(mstore, fmt.Sprintf("sid_%d", i), map[string]interface{}{"foo": i})
+		}
+
+		added := newTestShards("c")[0]
+		So(mstore.AddShard(added), ShouldBeNil)
+		So(shardSessions(added), ShouldEqual, 0)
+
+		for i := 0; i < 100; i++ {
+			sid := fmt.Sprintf("sid_%d", i)
+			exists, err := mstore.Check(ctx, sid)
+			So(err, ShouldBeNil)
+			So(exists, ShouldBeTrue)
+		}
+		So(shardSessions(added), ShouldEqual, 0)
+
+		for i := 0; i < 100; i++ {
+			store, err := mstore.Update(ctx, fmt.Sprintf("sid_%d", i), 10)
+			So(err, ShouldBeNil)
+			foo, _ := store.Get("foo")
+			So(foo, ShouldEqual, i)
+		}
+
+		moved := shardSessions(added)
+		So(moved, ShouldBeGreaterThan, 0)
+		So(shardSessions(shards[0])+shardSessions(shards[1])+moved, ShouldEqual, 100)
+
+		So(mstore.Delete(ctx, "sid_0"), ShouldBeNil)
+		exists, err := mstore.Check(ctx, "sid_0")
+		So(err, ShouldBeNil)
+		So(exists, ShouldBeFalse)
+	})
+}
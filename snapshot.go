@@ -0,0 +1,170 @@
+package session
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var _ SnapshotStore = &memoryStore{}
+
+// Implemented by session storages that can write all sessions to a stream
+// and load them back, e.g. to keep the sessions of a memory store across restarts
+type SnapshotStore interface {
+	// Write all sessions, including their expiration time. The sessions that
+	// can not be encoded are skipped and reported by a *SnapshotEncodeError
+	Snapshot(w io.Writer) error
+	// Load the sessions written by Snapshot, expired sessions are skipped
+	Restore(r io.Reader) error
+}
+
+// Returned by Snapshot when the values of some sessions can not be encoded,
+// the other sessions are written
+type SnapshotEncodeError struct {
+	// The encoding error of each skipped session
+	Errors map[string]error
+}
+
+func (e *SnapshotEncodeError) Error() string {
+	return fmt.Sprintf("Values of %d sessions could not be encoded in the snapshot", len(e.Errors))
+}
+
+// A session in a snapshot stream, the values are serialized with the gob codec
+type snapshotItem struct {
+	SID       string
+	ExpiredAt int64
+	Version   int64
+	Data      []byte
+}
+
+func (s *memoryStore) Snapshot(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	enc := gob.NewEncoder(bw)
+
+	var (
+		err     error
+		skipped *SnapshotEncodeError
+	)
+	s.data.Range(func(_ string, value interface{}) bool {
+		item := value.(*dataItem)
+		item.mu.RLock()
+		exists := item.exists()
+		rec := snapshotItem{
+			SID:       item.sid,
+			ExpiredAt: item.expiredAt.UnixNano(),
+			Version:   item.version,
+		}
+		values := item.values
+		item.mu.RUnlock()
+
+		if !exists {
+			return true
+		}
+
+		data, merr := defaultCodec.Marshal(values)
+		if merr != nil {
+			if skipped == nil {
+				skipped = &SnapshotEncodeError{Errors: make(map[string]error)}
+			}
+			skipped.Errors[rec.SID] = merr
+			return true
+		}
+
+		rec.Data = data
+		err = enc.Encode(&rec)
+		return err == nil
+	})
+	if err != nil {
+		return err
+	} else if err := bw.Flush(); err != nil {
+		return err
+	} else if skipped != nil {
+		return skipped
+	}
+	return nil
+}
+
+func (s *memoryStore) Restore(r io.Reader) error {
+	dec := gob.NewDecoder(bufio.NewReader(r))
+	for {
+		var rec snapshotItem
+		if err := dec.Decode(&rec); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if err := s.restore(rec); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *memoryStore) restore(rec snapshotItem) error {
+	expiredAt := time.Unix(0, rec.ExpiredAt)
+	if !expiredAt.After(now()) {
+		return nil
+	}
+
+	values, err := defaultCodec.Unmarshal(rec.Data)
+	if err != nil {
+		return err
+	}
+
+	item := s.lockItem(rec.SID, 0)
+	s.setExpiredAt(item, expiredAt)
+	s.write(item, values, nil)
+	if rec.Version > item.version {
+		item.version, item.writtenAt = rec.Version, rec.Version
+	}
+	err = s.logSave(item)
+	s.unlockItem(item)
+	s.evict(item)
+	return err
+}
+
+// Load the snapshot file, a missing file is not an error
+func (s *memoryStore) loadSnapshot(name string) error {
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return s.Restore(f)
+}
+
+// Write the snapshot file atomically
+func (s *memoryStore) saveSnapshot(name string) error {
+	f, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	// the sessions that can not be encoded are skipped, the others are kept
+	serr := s.Snapshot(f)
+	var encErr *SnapshotEncodeError
+	if serr != nil && !errors.As(serr, &encErr) {
+		f.Close()
+		return serr
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), name); err != nil {
+		return err
+	}
+	return serr
+}
@@ -0,0 +1,97 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMemoryStoreSnapshot(t *testing.T) {
+	Convey("Test memory store snapshot and restore", t, func() {
+		mstore := NewMemoryStore(SetMemoryStoreGCInterval(time.Hour))
+		defer mstore.Close()
+
+		ctx := context.Background()
+		saveMemorySession(mstore, "a", map[string]interface{}{"foo": "bar", "user": testCodecUser{ID: 1, Name: "foo"}})
+
+		store, err := mstore.Create(ctx, "expired", -1)
+		So(err, ShouldBeNil)
+		So(store.Save(), ShouldBeNil)
+
+		var buf bytes.Buffer
+		So(mstore.(SnapshotStore).Snapshot(&buf), ShouldBeNil)
+
+		restored := NewMemoryStore()
+		defer restored.Close()
+		So(restored.(SnapshotStore).Restore(&buf), ShouldBeNil)
+
+		exists, err := restored.Check(ctx, "expired")
+		So(err, ShouldBeNil)
+		So(exists, ShouldBeFalse)
+		So(restored.(MemoryStatsStore).Stats().Sessions, ShouldEqual, 1)
+
+		item, ok := restored.(*memoryStore).lockExisting("a")
+		So(ok, ShouldBeTrue)
+		expiredAt := item.expiredAt
+		item.mu.Unlock()
+
+		item, ok = mstore.(*memoryStore).lockExisting("a")
+		So(ok, ShouldBeTrue)
+		So(expiredAt.Equal(item.expiredAt), ShouldBeTrue)
+		item.mu.Unlock()
+
+		store, err = restored.Update(ctx, "a", 10)
+		So(err, ShouldBeNil)
+		foo, _ := store.Get("foo")
+		So(foo, ShouldEqual, "bar")
+		user, _ := store.Get("user")
+		So(user, ShouldResemble, testCodecUser{ID: 1, Name: "foo"})
+
+		So(restored.(SnapshotStore).Restore(bytes.NewReader([]byte("invalid"))), ShouldNotBeNil)
+	})
+
+	Convey("Test memory store snapshot file", t, func() {
+		name := filepath.Join(t.TempDir(), "sessions.snapshot")
+
+		mstore := NewMemoryStore(SetMemoryStoreSnapshotFile(name))
+		saveMemorySession(mstore, "a", map[string]interface{}{"foo": "bar"})
+		So(mstore.Close(), ShouldBeNil)
+
+		mstore = NewMemoryStore(SetMemoryStoreSnapshotFile(name))
+		defer mstore.Close()
+
+		store, err := mstore.Update(context.Background(), "a", 10)
+		So(err, ShouldBeNil)
+		foo, _ := store.Get("foo")
+		So(foo, ShouldEqual, "bar")
+	})
+
+	Convey("Test memory store snapshot skips the sessions that can not be encoded", t, func() {
+		name := filepath.Join(t.TempDir(), "sessions.snapshot")
+
+		mstore := NewMemoryStore(SetMemoryStoreSnapshotFile(name))
+		saveMemorySession(mstore, "a", map[string]interface{}{"foo": "bar"})
+		saveMemorySession(mstore, "b", map[string]interface{}{"foo": unregisteredValue{X: 1}})
+
+		err := mstore.Close()
+		encErr, ok := err.(*SnapshotEncodeError)
+		So(ok, ShouldBeTrue)
+		So(len(encErr.Errors), ShouldEqual, 1)
+		So(encErr.Errors["b"], ShouldNotBeNil)
+
+		mstore = NewMemoryStore(SetMemoryStoreSnapshotFile(name))
+		defer mstore.Close()
+
+		store, err := mstore.Update(context.Background(), "a", 10)
+		So(err, ShouldBeNil)
+		foo, _ := store.Get("foo")
+		So(foo, ShouldEqual, "bar")
+		exists, err := mstore.Check(context.Background(), "b")
+		So(err, ShouldBeNil)
+		So(exists, ShouldBeFalse)
+	})
+}
@@ -0,0 +1,299 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var _ ManagerStore = &sqlStore{}
+
+// Define the SQL syntax differences between databases,
+// the "{table}" token in the statements is replaced by the table name
+// and "?" is replaced by the placeholder of the dialect
+type SQLDialect struct {
+	// Name of the dialect
+	Name string
+	// Return the bind parameter placeholder of the n-th (starting at 1) argument
+	Placeholder func(n int) string
+	// Statements to create the session table and the expiry index
+	CreateTable []string
+	// Statement to insert or replace a session (sid, data, expired_at)
+	Upsert string
+}
+
+func questionPlaceholder(_ int) string {
+	return "?"
+}
+
+func dollarPlaceholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+// SQLite dialect
+var SQLiteDialect = SQLDialect{
+	Name:        "sqlite",
+	Placeholder: questionPlaceholder,
+	CreateTable: []string{
+		"CREATE TABLE IF NOT EXISTS {table} (sid VARCHAR(255) NOT NULL PRIMARY KEY, data BLOB NOT NULL, expired_at BIGINT NOT NULL)",
+		"CREATE INDEX IF NOT EXISTS idx_{table}_expired_at ON {table} (expired_at)",
+	},
+	Upsert: "INSERT INTO {table} (sid, data, expired_at) VALUES (?, ?, ?) ON CONFLICT (sid) DO UPDATE SET data = excluded.data, expired_at = excluded.expired_at",
+}
+
+// PostgreSQL dialect
+var PostgresDialect = SQLDialect{
+	Name:        "postgres",
+	Placeholder: dollarPlaceholder,
+	CreateTable: []string{
+		"CREATE TABLE IF NOT EXISTS {table} (sid VARCHAR(255) NOT NULL PRIMARY KEY, data BYTEA NOT NULL, expired_at BIGINT NOT NULL)",
+		"CREATE INDEX IF NOT EXISTS idx_{table}_expired_at ON {table} (expired_at)",
+	},
+	Upsert: "INSERT INTO {table} (sid, data, expired_at) VALUES (?, ?, ?) ON CONFLICT (sid) DO UPDATE SET data = EXCLUDED.data, expired_at = EXCLUDED.expired_at",
+}
+
+// MySQL dialect
+var MySQLDialect = SQLDialect{
+	Name:        "mysql",
+	Placeholder: questionPlaceholder,
+	CreateTable: []string{
+		"CREATE TABLE IF NOT EXISTS {table} (sid VARCHAR(255) NOT NULL PRIMARY KEY, data MEDIUMBLOB NOT NULL, expired_at BIGINT NOT NULL, INDEX idx_{table}_expired_at (expired_at))",
+	},
+	Upsert: "INSERT INTO {table} (sid, data, expired_at) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE data = VALUES(data), expired_at = VALUES(expired_at)",
+}
+
+// Define default sql store options
+var defaultSQLStoreOptions = sqlStoreOptions{
+	tableName:       "go_session",
+	gcInterval:      time.Minute,
+	autoCreateTable: true,
+}
+
+type sqlStoreOptions struct {
+	tableName       string
+	gcInterval      time.Duration
+	autoCreateTable bool
+}
+
+type SQLStoreOption func(*sqlStoreOptions)
+
+// Set the name of the session table
+func SetSQLStoreTableName(tableName string) SQLStoreOption {
+	return func(o *sqlStoreOptions) {
+		o.tableName = tableName
+	}
+}
+
+// Set the interval at which expired sessions are purged
+func SetSQLStoreGCInterval(interval time.Duration) SQLStoreOption {
+	return func(o *sqlStoreOptions) {
+		o.gcInterval = interval
+	}
+}
+
+// Create the session table on initialization (enabled by default)
+func SetSQLStoreAutoCreateTable(autoCreateTable bool) SQLStoreOption {
+	return func(o *sqlStoreOptions) {
+		o.autoCreateTable = autoCreateTable
+	}
+}
+
+// Create a new session storage (database/sql), the db is owned by the caller
+// and is not closed by the store
+func NewSQLStore(db *sql.DB, dialect SQLDialect, opt ...SQLStoreOption) (ManagerStore, error) {
+	opts := defaultSQLStoreOptions
+	for _, o := range opt {
+		o(&opts)
+	}
+	if opts.gcInterval <= 0 {
+		opts.gcInterval = defaultSQLStoreOptions.gcInterval
+	}
+
+	sstore := &sqlStore{
+		db:      db,
+		dialect: dialect,
+		opts:    &opts,
+		done:    make(chan struct{}),
+	}
+	sstore.buildStatements()
+
+	if opts.autoCreateTable {
+		for _, stmt := range dialect.CreateTable {
+			if _, err := db.Exec(sstore.rebind(stmt)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	sstore.wg.Add(1)
+	go sstore.gc()
+	return sstore, nil
+}
+
+type sqlStore struct {
+	db        *sql.DB
+	dialect   SQLDialect
+	opts      *sqlStoreOptions
+	done      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+
+	checkStmt  string
+	selectStmt string
+	upsertStmt string
+	expireStmt string
+	deleteStmt string
+	gcStmt     string
+}
+
+// replace the table token and the "?" placeholders of the statement
+func (s *sqlStore) rebind(stmt string) string {
+	stmt = strings.ReplaceAll(stmt, "{table}", s.opts.tableName)
+
+	var (
+		buf strings.Builder
+		n   int
+	)
+	for _, c := range stmt {
+		if c == '?' {
+			n++
+			buf.WriteString(s.dialect.Placeholder(n))
+			continue
+		}
+		buf.WriteRune(c)
+	}
+	return buf.String()
+}
+
+func (s *sqlStore) buildStatements() {
+	s.checkStmt = s.rebind("SELECT 1 FROM {table} WHERE sid = ? AND expired_at > ?")
+	s.selectStmt = s.rebind("SELECT data FROM {table} WHERE sid = ? AND expired_at > ?")
+	s.upsertStmt = s.rebind(s.dialect.Upsert)
+	s.expireStmt = s.rebind("UPDATE {table} SET expired_at = ? WHERE sid = ? AND expired_at > ?")
+	s.deleteStmt = s.rebind("DELETE FROM {table} WHERE sid = ?")
+	s.gcStmt = s.rebind("DELETE FROM {table} WHERE expired_at <= ?")
+}
+
+func unixExpiredAt(expired int64) int64 {
+	return now().Add(time.Duration(expired) * time.Second).Unix()
+}
+
+func (s *sqlStore) gc() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.opts.gcInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			_, _ = s.db.Exec(s.gcStmt, now().Unix())
+		}
+	}
+}
+
+// load the values of an unexpired session, returns nil if it does not exist
+func (s *sqlStore) load(ctx context.Context, sid string) (map[string]interface{}, error) {
+	var data []byte
+	err := s.db.QueryRowContext(ctx, s.selectStmt, sid, now().Unix()).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return decodeValues(ctx, data)
+}
+
+func (s *sqlStore) save(ctx context.Context, sid string, values map[string]interface{}, expired int64) error {
+	data, err := encodeValues(ctx, values)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, s.upsertStmt, sid, data, unixExpiredAt(expired))
+	return err
+}
+
+func (s *sqlStore) Check(ctx context.Context, sid string) (bool, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, s.checkStmt, sid, now().Unix()).Scan(&n)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *sqlStore) Create(ctx context.Context, sid string, expired int64) (Store, error) {
+	return newStore(ctx, s, sid, expired, nil), nil
+}
+
+func (s *sqlStore) Update(ctx context.Context, sid string, expired int64) (Store, error) {
+	values, err := s.load(ctx, sid)
+	if err != nil {
+		return nil, err
+	} else if values == nil {
+		return newStore(ctx, s, sid, expired, nil), nil
+	}
+
+	_, err = s.db.ExecContext(ctx, s.expireStmt, unixExpiredAt(expired), sid, now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	return newStore(ctx, s, sid, expired, values), nil
+}
+
+func (s *sqlStore) Delete(ctx context.Context, sid string) error {
+	_, err := s.db.ExecContext(ctx, s.deleteStmt, sid)
+	return err
+}
+
+func (s *sqlStore) Refresh(ctx context.Context, oldsid, sid string, expired int64) (Store, error) {
+	if oldsid == sid {
+		return s.Update(ctx, sid, expired)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var data []byte
+	err = tx.QueryRowContext(ctx, s.selectStmt, oldsid, now().Unix()).Scan(&data)
+	if err == sql.ErrNoRows {
+		return newStore(ctx, s, sid, expired, nil), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	values, err := decodeValues(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, s.upsertStmt, sid, data, unixExpiredAt(expired)); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, s.deleteStmt, oldsid); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return newStore(ctx, s, sid, expired, values), nil
+}
+
+func (s *sqlStore) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.wg.Wait()
+	})
+	return nil
+}
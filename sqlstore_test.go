@@ -0,0 +1,228 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// An in-memory database/sql driver that understands the statements of the sql store
+type fakeSQLDriver struct {
+	mu    sync.Mutex
+	rows  map[string]fakeSQLRow
+	stmts []string
+}
+
+type fakeSQLRow struct {
+	data      []byte
+	expiredAt int64
+}
+
+func newFakeSQLDB() (*sql.DB, *fakeSQLDriver) {
+	d := &fakeSQLDriver{rows: make(map[string]fakeSQLRow)}
+	return sql.OpenDB(d), d
+}
+
+func (d *fakeSQLDriver) Connect(context.Context) (driver.Conn, error) { return &fakeSQLConn{d}, nil }
+func (d *fakeSQLDriver) Driver() driver.Driver                        { return d }
+func (d *fakeSQLDriver) Open(string) (driver.Conn, error)             { return &fakeSQLConn{d}, nil }
+
+func (d *fakeSQLDriver) count() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.rows)
+}
+
+func (d *fakeSQLDriver) statements() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.stmts...)
+}
+
+type fakeSQLConn struct{ d *fakeSQLDriver }
+
+func (c *fakeSQLConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeSQLStmt{d: c.d, query: query}, nil
+}
+func (c *fakeSQLConn) Close() error              { return nil }
+func (c *fakeSQLConn) Begin() (driver.Tx, error) { return fakeSQLTx{}, nil }
+
+type fakeSQLTx struct{}
+
+func (fakeSQLTx) Commit() error   { return nil }
+func (fakeSQLTx) Rollback() error { return nil }
+
+type fakeSQLStmt struct {
+	d     *fakeSQLDriver
+	query string
+}
+
+func (s *fakeSQLStmt) Close() error  { return nil }
+func (s *fakeSQLStmt) NumInput() int { return -1 }
+
+func (s *fakeSQLStmt) Exec(args []driver.Value) (driver.Result, error) {
+	d := s.d
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stmts = append(d.stmts, s.query)
+
+	var affected int64
+	switch {
+	case strings.HasPrefix(s.query, "CREATE"):
+	case strings.HasPrefix(s.query, "INSERT"):
+		d.rows[args[0].(string)] = fakeSQLRow{data: args[1].([]byte), expiredAt: args[2].(int64)}
+		affected = 1
+	case strings.HasPrefix(s.query, "UPDATE"):
+		sid := args[1].(string)
+		if row, ok := d.rows[sid]; ok && row.expiredAt > args[2].(int64) {
+			row.expiredAt = args[0].(int64)
+			d.rows[sid] = row
+			affected = 1
+		}
+	case strings.HasPrefix(s.query, "DELETE") && strings.Contains(s.query, "WHERE sid"):
+		if _, ok := d.rows[args[0].(string)]; ok {
+			delete(d.rows, args[0].(string))
+			affected = 1
+		}
+	case strings.HasPrefix(s.query, "DELETE"):
+		for sid, row := range d.rows {
+			if row.expiredAt <= args[0].(int64) {
+				delete(d.rows, sid)
+				affected++
+			}
+		}
+	default:
+		return nil, errors.New("unsupported statement: " + s.query)
+	}
+	return driver.RowsAffected(affected), nil
+}
+
+func (s *fakeSQLStmt) Query(args []driver.Value) (driver.Rows, error) {
+	d := s.d
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stmts = append(d.stmts, s.query)
+
+	rows := &fakeSQLRows{}
+	row, ok := d.rows[args[0].(string)]
+	if ok && row.expiredAt <= args[1].(int64) {
+		ok = false
+	}
+
+	switch {
+	case strings.HasPrefix(s.query, "SELECT 1"):
+		rows.cols = []string{"1"}
+		if ok {
+			rows.vals = append(rows.vals, []driver.Value{int64(1)})
+		}
+	case strings.HasPrefix(s.query, "SELECT data"):
+		rows.cols = []string{"data"}
+		if ok {
+			rows.vals = append(rows.vals, []driver.Value{row.data})
+		}
+	default:
+		return nil, errors.New("unsupported query: " + s.query)
+	}
+	return rows, nil
+}
+
+type fakeSQLRows struct {
+	cols []string
+	vals [][]driver.Value
+}
+
+func (r *fakeSQLRows) Columns() []string { return r.cols }
+func (r *fakeSQLRows) Close() error      { return nil }
+
+func (r *fakeSQLRows) Next(dest []driver.Value) error {
+	if len(r.vals) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.vals[0])
+	r.vals = r.vals[1:]
+	return nil
+}
+
+func TestSQLStore(t *testing.T) {
+	db, _ := newFakeSQLDB()
+	mstore, err := NewSQLStore(db, SQLiteDialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mstore.Close()
+
+	Convey("Test sql storage operation", t, func() {
+		store, err := mstore.Create(context.Background(), "test_sql_store", 10)
+		So(err, ShouldBeNil)
+		testStore(store)
+	})
+}
+
+func TestManagerSQLStore(t *testing.T) {
+	db, fake := newFakeSQLDB()
+	mstore, err := NewSQLStore(db, PostgresDialect, SetSQLStoreTableName("sessions"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mstore.Close()
+
+	Convey("Test sql-based storage management operations", t, func() {
+		testManagerStore(mstore)
+		testRefreshSameID(mstore)
+
+		stmts := fake.statements()
+		So(stmts[0], ShouldStartWith, "CREATE TABLE IF NOT EXISTS sessions")
+		So(stmts[1], ShouldEqual, "CREATE INDEX IF NOT EXISTS idx_sessions_expired_at ON sessions (expired_at)")
+		for _, stmt := range stmts {
+			So(stmt, ShouldNotContainSubstring, "?")
+		}
+	})
+}
+
+func TestSQLStoreWithExpired(t *testing.T) {
+	db, _ := newFakeSQLDB()
+	mstore, err := NewSQLStore(db, MySQLDialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mstore.Close()
+
+	Convey("Test sql store expiration", t, func() {
+		testStoreWithExpired(mstore)
+	})
+}
+
+func TestSQLStoreGC(t *testing.T) {
+	db, fake := newFakeSQLDB()
+	mstore, err := NewSQLStore(db, SQLiteDialect, SetSQLStoreGCInterval(time.Millisecond*100))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("Test sql store purges expired sessions", t, func() {
+		store, err := mstore.Create(context.Background(), "test_sql_store_gc", 1)
+		So(err, ShouldBeNil)
+		So(store.Save(), ShouldBeNil)
+		So(fake.count(), ShouldEqual, 1)
+
+		time.Sleep(time.Millisecond * 2500)
+		So(fake.count(), ShouldEqual, 0)
+
+		So(mstore.Close(), ShouldBeNil)
+	})
+
+	Convey("Test sql store with a zero gc interval", t, func() {
+		db, _ := newFakeSQLDB()
+		mstore, err := NewSQLStore(db, SQLiteDialect, SetSQLStoreGCInterval(0))
+		So(err, ShouldBeNil)
+		So(mstore.Close(), ShouldBeNil)
+	})
+}
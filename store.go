@@ -1,7 +1,9 @@
 package session
 
 import (
+	"container/list"
 	"context"
+	"errors"
 	"sync"
 	"time"
 
@@ -9,11 +11,19 @@ import (
 )
 
 var (
-	_   ManagerStore = &memoryStore{}
-	_   Store        = &store{}
-	now              = time.Now
+	_   ManagerStore      = &memoryStore{}
+	_   Store             = &store{}
+	_   ChangeTracker     = &store{}
+	_   RangeStore        = &store{}
+	_   PatchStore        = &memoryStore{}
+	_   VersionStore      = &memoryStore{}
+	_   VersionPatchStore = &memoryStore{}
+	_   Locker            = &memoryStore{}
+	now                   = time.Now
 )
 
+var ErrConflict = errors.New("Session was modified by another request since it was read")
+
 // Management of session storage, including creation, update, and delete operations
 type ManagerStore interface {
 	// Check the session store exists
@@ -48,54 +58,399 @@ type Store interface {
 	Flush() error
 }
 
+// Define default memory store options
+var defaultMemoryStoreOptions = memoryStoreOptions{
+	gcInterval:     time.Second,
+	logSync:        LogSyncEverySecond,
+	logCompactSize: 64 << 20,
+}
+
+type memoryStoreOptions struct {
+	gcInterval     time.Duration
+	maxSessions    int
+	maxBytes       int64
+	onEvict        EvictionFunc
+	snapshotFile   string
+	logFile        string
+	logSync        LogSyncPolicy
+	logCompactSize int64
+}
+
+type MemoryStoreOption func(*memoryStoreOptions)
+
+// Set the interval of removing expired sessions
+func SetMemoryStoreGCInterval(interval time.Duration) MemoryStoreOption {
+	return func(o *memoryStoreOptions) {
+		o.gcInterval = interval
+	}
+}
+
+// Set the maximum number of sessions, the least recently used sessions
+// are evicted when it is exceeded (0 means unlimited)
+func SetMemoryStoreMaxSessions(maxSessions int) MemoryStoreOption {
+	return func(o *memoryStoreOptions) {
+		o.maxSessions = maxSessions
+	}
+}
+
+// Set the approximate maximum size of the session values (in bytes), the least
+// recently used sessions are evicted when it is exceeded (0 means unlimited)
+func SetMemoryStoreMaxBytes(maxBytes int64) MemoryStoreOption {
+	return func(o *memoryStoreOptions) {
+		o.maxBytes = maxBytes
+	}
+}
+
+// Set the callback of the sessions evicted to respect the capacity limits
+func SetMemoryStoreEvictionCallback(onEvict EvictionFunc) MemoryStoreOption {
+	return func(o *memoryStoreOptions) {
+		o.onEvict = onEvict
+	}
+}
+
+// Set the file the sessions are loaded from when the store is created
+// and written to when the store is closed. Sessions that expired in the
+// meantime are skipped, as well as the rest of an unreadable file
+func SetMemoryStoreSnapshotFile(name string) MemoryStoreOption {
+	return func(o *memoryStoreOptions) {
+		o.snapshotFile = name
+	}
+}
+
+// Set the append-only log recording the session writes, the sessions are
+// replayed from the snapshot file (name.snapshot by default) and the log
+// when the store is created. Write errors of the log are returned by Save
+func SetMemoryStoreLogFile(name string) MemoryStoreOption {
+	return func(o *memoryStoreOptions) {
+		o.logFile = name
+	}
+}
+
+// Set when the log is synced to disk (every second by default)
+func SetMemoryStoreLogSync(policy LogSyncPolicy) MemoryStoreOption {
+	return func(o *memoryStoreOptions) {
+		o.logSync = policy
+	}
+}
+
+// Set the size of the log (in bytes) that triggers its compaction into
+// the snapshot file in the background (64MB by default, 0 disables compaction)
+func SetMemoryStoreLogCompactSize(size int64) MemoryStoreOption {
+	return func(o *memoryStoreOptions) {
+		o.logCompactSize = size
+	}
+}
+
 // Create a new session storage (memory)
-func NewMemoryStore() ManagerStore {
+func NewMemoryStore(opt ...MemoryStoreOption) ManagerStore {
+	opts := defaultMemoryStoreOptions
+	for _, o := range opt {
+		o(&opts)
+	}
+	if opts.gcInterval <= 0 {
+		opts.gcInterval = defaultMemoryStoreOptions.gcInterval
+	}
+
 	mstore := &memoryStore{
-		ticker: time.NewTicker(time.Second),
-		data:   skipmap.NewString(),
+		memoryLocker: newMemoryLocker(),
+		opts:         &opts,
+		data:         skipmap.NewString(),
+		lru:          newLRUList(),
+		done:         make(chan struct{}),
 	}
 
+	if opts.logFile != "" {
+		if opts.snapshotFile == "" {
+			opts.snapshotFile = opts.logFile + ".snapshot"
+		}
+		mstore.compactc = make(chan struct{}, 1)
+		mstore.openLog()
+
+		mstore.wg.Add(1)
+		go mstore.maintainLog()
+	} else if opts.snapshotFile != "" {
+		_ = mstore.loadSnapshot(opts.snapshotFile)
+	}
+
+	mstore.wg.Add(1)
 	go mstore.gc()
 	return mstore
 }
 
+// The persisted state of a session, the values map is never modified
+// after it is stored so it can be shared by snapshots
 type dataItem struct {
+	mu        sync.RWMutex
 	sid       string
 	expiredAt time.Time
 	values    map[string]interface{}
+	version   int64
+	removed   bool
+
+	// the version of the last full write and the versions of the keys
+	// patched since, to tell the patches that conflict apart
+	writtenAt   int64
+	keyVersions map[string]int64
+
+	// the position in the expiry queue, guarded by the queue lock
+	queueIndex int
+	queueAt    time.Time
+
+	// the position in the lru list, guarded by the list lock
+	lruElem *list.Element
+	lruSize int64
 }
 
 func newDataItem(sid string, values map[string]interface{}, expired int64) *dataItem {
 	return &dataItem{
-		sid:       sid,
-		expiredAt: now().Add(time.Duration(expired) * time.Second),
-		values:    values,
+		sid:        sid,
+		expiredAt:  now().Add(time.Duration(expired) * time.Second),
+		values:     values,
+		queueIndex: -1,
 	}
 }
 
+// An item with version 0 is being created and has no values yet
+func (item *dataItem) exists() bool {
+	return !item.removed && item.version > 0
+}
+
+// Report whether one of the keys was written since version,
+// or the whole session if keys is nil
+func (item *dataItem) modifiedSince(version int64, keys []string) bool {
+	if keys == nil || item.version < version || item.writtenAt > version {
+		return item.version != version
+	}
+	for _, key := range keys {
+		if item.keyVersions[key] > version {
+			return true
+		}
+	}
+	return false
+}
+
 type memoryStore struct {
-	ticker *time.Ticker
-	data   *skipmap.StringMap
+	*memoryLocker
+	opts      *memoryStoreOptions
+	data      *skipmap.StringMap
+	expiry    expiryQueue
+	lru       *lruList
+	log       *appendLog
+	compactc  chan struct{}
+	done      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
 }
 
 func (s *memoryStore) gc() {
-	for range s.ticker.C {
-		s.data.Range(func(key string, value interface{}) bool {
-			if item, ok := value.(*dataItem); ok && item.expiredAt.Before(now()) {
-				s.data.Delete(key)
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.opts.gcInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.removeExpired(now())
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *memoryStore) removeExpired(t time.Time) {
+	for _, item := range s.expiry.due(t) {
+		item.mu.Lock()
+		if !item.removed {
+			if item.expiredAt.Before(t) {
+				s.remove(item)
+			} else {
+				// the expiration was extended after the item was dequeued
+				s.expiry.schedule(item, item.expiredAt)
 			}
-			return true
-		})
+		}
+		item.mu.Unlock()
+	}
+}
+
+// Set the expiration time of the item, item.mu must be held
+func (s *memoryStore) setExpired(item *dataItem, expired int64) {
+	s.setExpiredAt(item, now().Add(time.Duration(expired)*time.Second))
+}
+
+func (s *memoryStore) setExpiredAt(item *dataItem, expiredAt time.Time) {
+	item.expiredAt = expiredAt
+	s.expiry.schedule(item, expiredAt)
+}
+
+// Delete the item from the map, item.mu must be held
+func (s *memoryStore) remove(item *dataItem) {
+	item.removed = true
+	s.data.Delete(item.sid)
+	s.expiry.unschedule(item)
+	s.lru.remove(item)
+}
+
+// Write the values and bump the version of the item, item.mu must be held
+func (s *memoryStore) write(item *dataItem, values map[string]interface{}, patched []string) int64 {
+	item.values = values
+	item.version++
+	if patched == nil {
+		item.writtenAt, item.keyVersions = item.version, nil
+	} else {
+		if item.keyVersions == nil {
+			item.keyVersions = make(map[string]int64, len(patched))
+		}
+		for _, key := range patched {
+			item.keyVersions[key] = item.version
+		}
 	}
+	s.lru.touch(item, approxValuesSize(values))
+	return item.version
 }
 
-func (s *memoryStore) save(sid string, values map[string]interface{}, expired int64) {
-	if dt, ok := s.data.Load(sid); ok {
-		dt.(*dataItem).values = values
+// Evict the least recently used sessions if the capacity limits are exceeded,
+// the item that was just written is kept. No item lock may be held
+func (s *memoryStore) evict(written *dataItem) {
+	if s.opts.maxSessions <= 0 && s.opts.maxBytes <= 0 {
 		return
 	}
 
-	s.data.Store(sid, newDataItem(sid, values, expired))
+	for _, item := range s.lru.victims(s.opts.maxSessions, s.opts.maxBytes, written) {
+		item.mu.Lock()
+		if !item.exists() {
+			item.mu.Unlock()
+			continue
+		}
+		s.remove(item)
+		_ = s.appendLog(logRecord{op: logOpDelete, sid: item.sid})
+		values := item.values
+		item.mu.Unlock()
+
+		if s.opts.onEvict != nil {
+			s.opts.onEvict(item.sid, values)
+		}
+	}
+}
+
+// Get the counters of the memory store
+func (s *memoryStore) Stats() MemoryStoreStats {
+	return s.lru.stats()
+}
+
+// Get the item of a session locked for writing, the item is created if it
+// does not exist. Writes of a session are serialized by the item lock
+func (s *memoryStore) lockItem(sid string, expired int64) *dataItem {
+	for {
+		dt, loaded := s.data.LoadOrStoreLazy(sid, func() interface{} {
+			return newDataItem(sid, nil, expired)
+		})
+
+		item := dt.(*dataItem)
+		item.mu.Lock()
+		if !item.removed {
+			if !loaded {
+				s.expiry.schedule(item, item.expiredAt)
+			}
+			return item
+		}
+		// removed concurrently, retry with a new item
+		item.mu.Unlock()
+	}
+}
+
+// Unlock the item, an item created without a successful write is removed
+func (s *memoryStore) unlockItem(item *dataItem) {
+	if item.version == 0 && !item.removed {
+		s.remove(item)
+	}
+	item.mu.Unlock()
+}
+
+// Get the existing item of a session locked for writing
+func (s *memoryStore) lockExisting(sid string) (*dataItem, bool) {
+	dt, ok := s.data.Load(sid)
+	if !ok {
+		return nil, false
+	}
+
+	item := dt.(*dataItem)
+	item.mu.Lock()
+	if !item.exists() {
+		item.mu.Unlock()
+		return nil, false
+	}
+	return item, true
+}
+
+func (s *memoryStore) save(_ context.Context, sid string, values map[string]interface{}, expired int64) error {
+	item := s.lockItem(sid, expired)
+	_, err := s.commit(item, copyValues(values), nil)
+	s.unlockItem(item)
+	s.evict(item)
+	return err
+}
+
+func (s *memoryStore) SaveVersion(_ context.Context, sid string, values map[string]interface{}, expired int64, version int64) (int64, error) {
+	item := s.lockItem(sid, expired)
+	if item.modifiedSince(version, nil) {
+		s.unlockItem(item)
+		return 0, ErrConflict
+	}
+
+	version, err := s.commit(item, copyValues(values), nil)
+	s.unlockItem(item)
+	s.evict(item)
+	return version, err
+}
+
+func (s *memoryStore) Patch(_ context.Context, sid string, changed map[string]interface{}, deleted []string, expired int64) error {
+	item := s.lockItem(sid, expired)
+	_, err := s.patch(item, changed, deleted)
+	s.unlockItem(item)
+	s.evict(item)
+	return err
+}
+
+func (s *memoryStore) PatchVersion(_ context.Context, sid string, changed map[string]interface{}, deleted []string, expired int64, version int64) (int64, error) {
+	item := s.lockItem(sid, expired)
+	if item.modifiedSince(version, patchedKeys(changed, deleted)) {
+		s.unlockItem(item)
+		return 0, ErrConflict
+	}
+
+	version, err := s.patch(item, changed, deleted)
+	s.unlockItem(item)
+	s.evict(item)
+	return version, err
+}
+
+// Apply the changes to a copy of the values of the item, item.mu must be held
+func (s *memoryStore) patch(item *dataItem, changed map[string]interface{}, deleted []string) (int64, error) {
+	values := copyValues(item.values)
+	for key, value := range changed {
+		values[key] = value
+	}
+	for _, key := range deleted {
+		delete(values, key)
+	}
+	return s.commit(item, values, patchedKeys(changed, deleted))
+}
+
+func patchedKeys(changed map[string]interface{}, deleted []string) []string {
+	keys := make([]string, 0, len(changed)+len(deleted))
+	for key := range changed {
+		keys = append(keys, key)
+	}
+	return append(keys, deleted...)
+}
+
+func copyValues(values map[string]interface{}) map[string]interface{} {
+	cp := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		cp[key] = value
+	}
+	return cp
 }
 
 func (s *memoryStore) Check(ctx context.Context, sid string) (bool, error) {
@@ -104,76 +459,222 @@ func (s *memoryStore) Check(ctx context.Context, sid string) (bool, error) {
 		return false, nil
 	}
 
-	if item, ok := dt.(*dataItem); ok && item.expiredAt.After(now()) {
-		return true, nil
-	}
-	return false, nil
+	item := dt.(*dataItem)
+	item.mu.RLock()
+	defer item.mu.RUnlock()
+	return item.exists() && item.expiredAt.After(now()), nil
 }
 
 func (s *memoryStore) Create(ctx context.Context, sid string, expired int64) (Store, error) {
 	return newStore(ctx, s, sid, expired, nil), nil
 }
 
+// The session store works on a snapshot of the stored values,
+// so that concurrent session stores of a sid do not share a map
 func (s *memoryStore) Update(ctx context.Context, sid string, expired int64) (Store, error) {
-	dt, ok := s.data.Load(sid)
+	item, ok := s.lockExisting(sid)
 	if !ok {
 		return newStore(ctx, s, sid, expired, nil), nil
 	}
+	defer item.mu.Unlock()
 
-	item := dt.(*dataItem)
-	item.expiredAt = now().Add(time.Duration(expired) * time.Second)
-	s.data.Store(sid, item)
-	return newStore(ctx, s, sid, expired, item.values), nil
-}
+	s.setExpired(item, expired)
+	s.lru.touch(item, -1)
+	err := s.appendLog(logRecord{op: logOpExpire, sid: sid, expiredAt: item.expiredAt.UnixNano()})
+	if err != nil {
+		return nil, err
+	}
 
-func (s *memoryStore) delete(sid string) {
-	s.data.Delete(sid)
+	store := newStore(ctx, s, sid, expired, copyValues(item.values))
+	store.version = item.version
+	return store, nil
 }
 
 func (s *memoryStore) Delete(_ context.Context, sid string) error {
-	s.delete(sid)
-	return nil
+	item, ok := s.lockExisting(sid)
+	if !ok {
+		return nil
+	}
+	defer item.mu.Unlock()
+
+	s.remove(item)
+	return s.appendLog(logRecord{op: logOpDelete, sid: sid})
 }
 
 func (s *memoryStore) Refresh(ctx context.Context, oldsid, sid string, expired int64) (Store, error) {
-	dt, ok := s.data.Load(oldsid)
+	if oldsid == sid {
+		return s.Update(ctx, sid, expired)
+	}
+
+	item, ok := s.lockExisting(oldsid)
 	if !ok {
 		return newStore(ctx, s, sid, expired, nil), nil
 	}
+	defer item.mu.Unlock()
 
-	item := dt.(*dataItem)
-	newItem := newDataItem(sid, item.values, expired)
-	s.data.Store(sid, newItem)
-	s.delete(oldsid)
-	return newStore(ctx, s, sid, expired, newItem.values), nil
+	expiredAt := now().Add(time.Duration(expired) * time.Second)
+	s.moveItem(item, sid, expiredAt)
+	err := s.appendLog(logRecord{op: logOpRefresh, sid: sid, oldsid: oldsid, expiredAt: expiredAt.UnixNano()})
+	if err != nil {
+		return nil, err
+	}
+
+	store := newStore(ctx, s, sid, expired, copyValues(item.values))
+	store.version = item.version
+	return store, nil
 }
 
+// Move the values of the item to sid, item.mu must be held
+func (s *memoryStore) moveItem(item *dataItem, sid string, expiredAt time.Time) {
+	newItem := s.lockItem(sid, 0)
+	s.setExpiredAt(newItem, expiredAt)
+	newItem.values = item.values
+	newItem.version = item.version
+	newItem.writtenAt = item.writtenAt
+	newItem.keyVersions = item.keyVersions
+	s.lru.touch(newItem, approxValuesSize(item.values))
+	s.unlockItem(newItem)
+	s.remove(item)
+}
+
+// Close stops the background goroutines and waits for them to return,
+// then compacts the log or writes the snapshot file if one is set
 func (s *memoryStore) Close() error {
-	s.ticker.Stop()
-	return nil
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.wg.Wait()
+
+		if s.log != nil {
+			err = s.compact()
+			if cerr := s.log.close(); err == nil {
+				err = cerr
+			}
+		} else if s.opts.snapshotFile != "" {
+			err = s.saveSnapshot(s.opts.snapshotFile)
+		}
+	})
+	return err
+}
+
+// The persistence backend used by a session store to save its values
+type storeSaver interface {
+	save(ctx context.Context, sid string, values map[string]interface{}, expired int64) error
+}
+
+// Implemented by session storages of other packages to save the values of
+// the session stores created with NewStore
+type SaveStore interface {
+	// Write all values of a session and specify the expiration time (in seconds)
+	SaveValues(ctx context.Context, sid string, values map[string]interface{}, expired int64) error
+}
+
+// Adapt a SaveStore to the persistence backend of a session store
+type saveStoreSaver struct {
+	SaveStore
+}
+
+func (s saveStoreSaver) save(ctx context.Context, sid string, values map[string]interface{}, expired int64) error {
+	return s.SaveValues(ctx, sid, values, expired)
+}
+
+// Implemented by session storages that can persist only the modified values
+// of a session, the session store falls back to writing all values when
+// the storage does not implement it
+type PatchStore interface {
+	// Write the changed values and remove the deleted keys of a session store
+	// and specify the expiration time (in seconds)
+	Patch(ctx context.Context, sid string, changed map[string]interface{}, deleted []string, expired int64) error
+}
+
+// Implemented by session storages that keep a version counter for each
+// session, so that a session store fails to save with ErrConflict when
+// the session was written by another session store since it was read.
+// The memory and memcache stores are versioned, saves to the other built-in
+// storages (file, sql, redis, cookie) and to the store wrappers are last-writer-wins
+type VersionStore interface {
+	// Write the values of a session store if the stored version still equals
+	// version (0 if not persisted yet) and return the new version
+	SaveVersion(ctx context.Context, sid string, values map[string]interface{}, expired int64, version int64) (int64, error)
+}
+
+// Implemented by versioned session storages that can persist only the modified
+// values of a session, so that session stores changing different keys do not
+// conflict. The patch fails with ErrConflict when one of its keys was written
+// since version, or the whole session was written or removed since then
+type VersionPatchStore interface {
+	// Write the changed values and remove the deleted keys of a session store
+	// read at version and return the new version, which is version+1 if
+	// the session was not written by others since version
+	PatchVersion(ctx context.Context, sid string, changed map[string]interface{}, deleted []string, expired int64, version int64) (int64, error)
+}
+
+// Implemented by session stores that can iterate over their values
+type RangeStore interface {
+	// Call f for each session value until it returns false
+	Range(f func(key string, value interface{}) bool)
+}
+
+// Implemented by session stores that track the modifications made since
+// the last save, so that unchanged sessions are not written again
+type ChangeTracker interface {
+	// Report whether the session values were modified since the last save
+	IsDirty() bool
+	// Return the keys set and deleted since the last save,
+	// flushed reports whether all values were cleared
+	Changes() (changed, deleted []string, flushed bool)
+}
+
+// Create a session store of a session storage of another package, values
+// are the stored session values (nil if not persisted yet) and version the
+// stored version of the session if the storage is versioned. The session store
+// tracks its modifications like the stores of the built-in storages, Save
+// writes only the changes when mstore implements PatchStore or VersionPatchStore
+// and checks the version when it implements VersionStore or VersionPatchStore
+func NewStore(ctx context.Context, mstore SaveStore, sid string, expired int64, values map[string]interface{}, version int64) Store {
+	s := newStore(ctx, saveStoreSaver{mstore}, sid, expired, values)
+	s.backend = mstore
+	s.version = version
+	return s
 }
 
-func newStore(ctx context.Context, mstore *memoryStore, sid string, expired int64, values map[string]interface{}) *store {
+// A session created without values has not been persisted yet,
+// so it starts dirty and the first save always writes it
+func newStore(ctx context.Context, mstore storeSaver, sid string, expired int64, values map[string]interface{}) *store {
+	isNew := values == nil
 	if values == nil {
 		values = make(map[string]interface{})
 	}
 
 	return &store{
 		mstore:  mstore,
+		backend: mstore,
 		ctx:     ctx,
 		sid:     sid,
 		expired: expired,
 		values:  values,
+		isNew:   isNew,
+		dirty:   isNew,
+		changed: make(map[string]struct{}),
+		deleted: make(map[string]struct{}),
 	}
 }
 
 type store struct {
 	sync.RWMutex
-	mstore  *memoryStore
+	mstore  storeSaver
+	backend interface{} // checked for the optional storage interfaces
 	ctx     context.Context
 	sid     string
 	expired int64
 	values  map[string]interface{}
+	version int64
+	seen    map[string]int64 // versions of the keys patched over the writes of others
+	isNew   bool
+	dirty   bool
+	flushed bool
+	changed map[string]struct{}
+	deleted map[string]struct{}
 }
 
 func (s *store) Context() context.Context {
@@ -187,6 +688,9 @@ func (s *store) SessionID() string {
 func (s *store) Set(key string, value interface{}) {
 	s.Lock()
 	s.values[key] = value
+	s.changed[key] = struct{}{}
+	delete(s.deleted, key)
+	s.dirty = true
 	s.Unlock()
 }
 
@@ -198,31 +702,165 @@ func (s *store) Get(key string) (interface{}, bool) {
 }
 
 func (s *store) Delete(key string) interface{} {
-	s.RLock()
+	s.Lock()
 	v, ok := s.values[key]
-	s.RUnlock()
-
 	if ok {
-		s.Lock()
 		delete(s.values, key)
-		s.Unlock()
+		delete(s.changed, key)
+		s.deleted[key] = struct{}{}
+		s.dirty = true
 	}
+	s.Unlock()
 	return v
 }
 
 func (s *store) Flush() error {
 	s.Lock()
 	s.values = make(map[string]interface{})
+	s.changed = make(map[string]struct{})
+	s.deleted = make(map[string]struct{})
+	s.flushed = true
+	s.dirty = true
 	s.Unlock()
 
 	return s.Save()
 }
 
-func (s *store) Save() error {
+func (s *store) IsDirty() bool {
 	s.RLock()
-	values := s.values
-	s.RUnlock()
+	defer s.RUnlock()
+	return s.dirty
+}
+
+// Range holds the read lock, f must not modify the session store
+func (s *store) Range(f func(key string, value interface{}) bool) {
+	s.RLock()
+	defer s.RUnlock()
+
+	for key, value := range s.values {
+		if !f(key, value) {
+			return
+		}
+	}
+}
+
+func (s *store) Changes() (changed, deleted []string, flushed bool) {
+	s.RLock()
+	defer s.RUnlock()
+
+	for key := range s.changed {
+		changed = append(changed, key)
+	}
+	for key := range s.deleted {
+		deleted = append(deleted, key)
+	}
+	return changed, deleted, s.flushed
+}
+
+// reset the change set after a successful save
+func (s *store) resetChanges() {
+	s.isNew = false
+	s.dirty = false
+	s.flushed = false
+	s.changed = make(map[string]struct{})
+	s.deleted = make(map[string]struct{})
+}
+
+// Save is a no-op if nothing changed since the last save, the lock is held
+// while saving so that concurrent modifications are not lost from the change set.
+// Only the changes are written when the storage supports it, a session store
+// that is new or was flushed is written in full. Versioned storages reject
+// stale session stores with ErrConflict
+func (s *store) Save() error {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.dirty {
+		return nil
+	}
 
-	s.mstore.save(s.sid, values, s.expired)
+	partial := !s.isNew && !s.flushed
+	vpstore, vpok := s.backend.(VersionPatchStore)
+	vstore, vok := s.backend.(VersionStore)
+	pstore, pok := s.backend.(PatchStore)
+
+	var err error
+	switch {
+	case partial && vpok:
+		changed, deleted := s.changes()
+		keys := patchedKeys(changed, deleted)
+		read := s.readVersion(keys)
+		var version int64
+		version, err = vpstore.PatchVersion(s.ctx, s.sid, changed, deleted, s.expired, read)
+		if err == nil {
+			s.patched(keys, read, version)
+		}
+	case vok:
+		var version int64
+		version, err = vstore.SaveVersion(s.ctx, s.sid, s.values, s.expired, s.version)
+		if err == nil {
+			s.version, s.seen = version, nil
+		}
+	case partial && pok:
+		changed, deleted := s.changes()
+		err = pstore.Patch(s.ctx, s.sid, changed, deleted, s.expired)
+	default:
+		err = s.mstore.save(s.ctx, s.sid, s.values, s.expired)
+	}
+	if err != nil {
+		return err
+	}
+	s.resetChanges()
 	return nil
 }
+
+// Get the version at which the keys were last read or written by the session
+// store, the version read unless all keys were patched since then, s.mu must be held
+func (s *store) readVersion(keys []string) int64 {
+	if len(s.seen) == 0 || len(keys) == 0 {
+		return s.version
+	}
+
+	version := int64(-1)
+	for _, key := range keys {
+		v, ok := s.seen[key]
+		if !ok {
+			return s.version
+		} else if version < 0 || v < version {
+			version = v
+		}
+	}
+	return version
+}
+
+// Record the version written by a patch checked against read. The session
+// store only moves to the new version when no other write came in between,
+// otherwise it has not read the values written by the others and only
+// the patched keys are known at the new version, s.mu must be held
+func (s *store) patched(keys []string, read, version int64) {
+	if read == s.version && version == read+1 {
+		s.version, s.seen = version, nil
+		return
+	}
+
+	if s.seen == nil {
+		s.seen = make(map[string]int64, len(keys))
+	}
+	for _, key := range keys {
+		s.seen[key] = version
+	}
+}
+
+// Get the values set and the keys deleted since the last save, s.mu must be held
+func (s *store) changes() (map[string]interface{}, []string) {
+	changed := make(map[string]interface{}, len(s.changed))
+	for key := range s.changed {
+		changed[key] = s.values[key]
+	}
+
+	deleted := make([]string, 0, len(s.deleted))
+	for key := range s.deleted {
+		deleted = append(deleted, key)
+	}
+	return changed, deleted
+}
@@ -2,6 +2,8 @@ package session
 
 import (
 	"context"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 
@@ -92,6 +94,25 @@ func testManagerStore(mstore ManagerStore) {
 	So(err, ShouldBeNil)
 }
 
+// Refreshing a session to the same session id keeps it
+func testRefreshSameID(mstore ManagerStore) {
+	ctx := context.Background()
+	sid := "test_refresh_same_id"
+	store, err := mstore.Create(ctx, sid, 10)
+	So(err, ShouldBeNil)
+	store.Set("foo", "bar")
+	So(store.Save(), ShouldBeNil)
+
+	store, err = mstore.Refresh(ctx, sid, sid, 10)
+	So(err, ShouldBeNil)
+	foo, _ := store.Get("foo")
+	So(foo, ShouldEqual, "bar")
+
+	exists, err := mstore.Check(ctx, sid)
+	So(err, ShouldBeNil)
+	So(exists, ShouldBeTrue)
+}
+
 func TestManagerMemoryStore(t *testing.T) {
 	mstore := NewMemoryStore()
 
@@ -132,3 +153,381 @@ func TestMemoryStoreWithExpired(t *testing.T) {
 		testStoreWithExpired(mstore)
 	})
 }
+
+func TestMemoryStoreGC(t *testing.T) {
+	Convey("Test memory store gc removes the due sessions only", t, func() {
+		mstore := NewMemoryStore(SetMemoryStoreGCInterval(time.Hour)).(*memoryStore)
+		defer mstore.Close()
+
+		ctx := context.Background()
+		for i, expired := range []int64{10, 20, 30} {
+			store, err := mstore.Create(ctx, fmt.Sprintf("test_memory_store_gc_%d", i), expired)
+			So(err, ShouldBeNil)
+			So(store.Save(), ShouldBeNil)
+		}
+		So(mstore.expiry.len(), ShouldEqual, 3)
+
+		// extend the first session beyond the others
+		_, err := mstore.Update(ctx, "test_memory_store_gc_0", 40)
+		So(err, ShouldBeNil)
+
+		mstore.removeExpired(time.Now().Add(time.Second * 25))
+		So(mstore.expiry.len(), ShouldEqual, 2)
+		So(mstore.data.Len(), ShouldEqual, 2)
+
+		exists, err := mstore.Check(ctx, "test_memory_store_gc_1")
+		So(err, ShouldBeNil)
+		So(exists, ShouldBeFalse)
+		exists, err = mstore.Check(ctx, "test_memory_store_gc_0")
+		So(err, ShouldBeNil)
+		So(exists, ShouldBeTrue)
+
+		So(mstore.Delete(ctx, "test_memory_store_gc_2"), ShouldBeNil)
+		So(mstore.expiry.len(), ShouldEqual, 1)
+	})
+
+	Convey("Test memory store close stops the gc", t, func() {
+		mstore := NewMemoryStore(SetMemoryStoreGCInterval(time.Millisecond)).(*memoryStore)
+		time.Sleep(time.Millisecond * 10)
+
+		done := make(chan struct{})
+		go func() {
+			_ = mstore.Close()
+			_ = mstore.Close()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("Close did not return")
+		}
+	})
+}
+
+type countingSaver struct {
+	saves int
+}
+
+func (s *countingSaver) save(_ context.Context, _ string, _ map[string]interface{}, _ int64) error {
+	s.saves++
+	return nil
+}
+
+func TestStoreDirty(t *testing.T) {
+	Convey("Test store dirty tracking", t, func() {
+		saver := &countingSaver{}
+		store := newStore(context.Background(), saver, "test_store_dirty", 10, nil)
+		So(store.IsDirty(), ShouldBeTrue)
+
+		So(store.Save(), ShouldBeNil)
+		So(saver.saves, ShouldEqual, 1)
+		So(store.IsDirty(), ShouldBeFalse)
+
+		So(store.Save(), ShouldBeNil)
+		So(saver.saves, ShouldEqual, 1)
+
+		store.Set("foo", "bar")
+		store.Set("foo2", "bar2")
+		store.Delete("foo2")
+		store.Delete("missing")
+		So(store.IsDirty(), ShouldBeTrue)
+
+		changed, deleted, flushed := store.Changes()
+		So(changed, ShouldResemble, []string{"foo"})
+		So(deleted, ShouldResemble, []string{"foo2"})
+		So(flushed, ShouldBeFalse)
+
+		So(store.Save(), ShouldBeNil)
+		So(saver.saves, ShouldEqual, 2)
+
+		changed, deleted, _ = store.Changes()
+		So(changed, ShouldBeEmpty)
+		So(deleted, ShouldBeEmpty)
+
+		store = newStore(context.Background(), saver, "test_store_dirty", 10, map[string]interface{}{"foo": "bar"})
+		So(store.IsDirty(), ShouldBeFalse)
+		store.Delete("missing")
+		So(store.IsDirty(), ShouldBeFalse)
+
+		So(store.Flush(), ShouldBeNil)
+		So(saver.saves, ShouldEqual, 3)
+		So(store.IsDirty(), ShouldBeFalse)
+	})
+}
+
+type patchingSaver struct {
+	countingSaver
+	patches int
+	changed map[string]interface{}
+	deleted []string
+}
+
+func (s *patchingSaver) Patch(_ context.Context, _ string, changed map[string]interface{}, deleted []string, _ int64) error {
+	s.patches++
+	s.changed, s.deleted = changed, deleted
+	return nil
+}
+
+// A storage of another package saving through SaveValues
+type externalPatchStore struct {
+	patchingSaver
+}
+
+func (s *externalPatchStore) SaveValues(ctx context.Context, sid string, values map[string]interface{}, expired int64) error {
+	return s.save(ctx, sid, values, expired)
+}
+
+func TestStorePatch(t *testing.T) {
+	Convey("Test store saves the changed values only", t, func() {
+		saver := &patchingSaver{}
+		store := newStore(context.Background(), saver, "test_store_patch", 10, nil)
+		store.Set("foo", "bar")
+		So(store.Save(), ShouldBeNil)
+		So(saver.saves, ShouldEqual, 1)
+		So(saver.patches, ShouldEqual, 0)
+
+		store.Set("foo2", "bar2")
+		store.Delete("foo")
+		So(store.Save(), ShouldBeNil)
+		So(saver.saves, ShouldEqual, 1)
+		So(saver.patches, ShouldEqual, 1)
+		So(saver.changed, ShouldResemble, map[string]interface{}{"foo2": "bar2"})
+		So(saver.deleted, ShouldResemble, []string{"foo"})
+
+		So(store.Flush(), ShouldBeNil)
+		So(saver.saves, ShouldEqual, 2)
+		So(saver.patches, ShouldEqual, 1)
+	})
+
+	Convey("Test store of another package saves the changed values only", t, func() {
+		saver := &externalPatchStore{}
+		store := NewStore(context.Background(), saver, "test_store_patch", 10, map[string]interface{}{"foo": "bar"}, 0)
+		So(store.Save(), ShouldBeNil)
+		So(saver.saves, ShouldEqual, 0)
+
+		store.Set("foo2", "bar2")
+		So(store.Save(), ShouldBeNil)
+		So(saver.saves, ShouldEqual, 0)
+		So(saver.patches, ShouldEqual, 1)
+		So(saver.changed, ShouldResemble, map[string]interface{}{"foo2": "bar2"})
+
+		So(store.Flush(), ShouldBeNil)
+		So(saver.saves, ShouldEqual, 1)
+	})
+
+	Convey("Test memory store merges concurrent session changes", t, func() {
+		mstore := NewMemoryStore()
+		defer mstore.Close()
+
+		ctx := context.Background()
+		sid := "test_memory_store_patch"
+		store, err := mstore.Create(ctx, sid, 10)
+		So(err, ShouldBeNil)
+		store.Set("foo", "bar")
+		So(store.Save(), ShouldBeNil)
+
+		store1, err := mstore.Update(ctx, sid, 10)
+		So(err, ShouldBeNil)
+		store2, err := mstore.Update(ctx, sid, 10)
+		So(err, ShouldBeNil)
+
+		store1.Set("foo1", "bar1")
+		store2.Set("foo2", "bar2")
+		store2.Delete("foo")
+		So(store1.Save(), ShouldBeNil)
+		So(store2.Save(), ShouldBeNil)
+
+		store, err = mstore.Update(ctx, sid, 10)
+		So(err, ShouldBeNil)
+		_, ok := store.Get("foo")
+		So(ok, ShouldBeFalse)
+		foo1, _ := store.Get("foo1")
+		So(foo1, ShouldEqual, "bar1")
+		foo2, _ := store.Get("foo2")
+		So(foo2, ShouldEqual, "bar2")
+	})
+}
+
+func TestMemoryStoreVersion(t *testing.T) {
+	Convey("Test memory store rejects stale session stores", t, func() {
+		mstore := NewMemoryStore()
+		defer mstore.Close()
+
+		ctx := context.Background()
+		sid := "test_memory_store_version"
+		store, err := mstore.Create(ctx, sid, 10)
+		So(err, ShouldBeNil)
+		store.Set("foo", "bar")
+		So(store.Save(), ShouldBeNil)
+
+		store1, err := mstore.Update(ctx, sid, 10)
+		So(err, ShouldBeNil)
+		store2, err := mstore.Update(ctx, sid, 10)
+		So(err, ShouldBeNil)
+
+		store1.Set("foo1", "bar1")
+		So(store1.Save(), ShouldBeNil)
+		store2.Set("foo1", "bar2")
+		So(store2.Save(), ShouldEqual, ErrConflict)
+		store2.Delete("foo")
+		So(store2.Save(), ShouldEqual, ErrConflict)
+
+		store1.Set("foo1", "bar2")
+		So(store1.Save(), ShouldBeNil)
+
+		// a flushed session store is written in full
+		store3, err := mstore.Update(ctx, sid, 10)
+		So(err, ShouldBeNil)
+		store1.Set("foo3", "bar3")
+		So(store1.Save(), ShouldBeNil)
+		So(store3.Flush(), ShouldEqual, ErrConflict)
+
+		created, err := mstore.Create(ctx, sid, 10)
+		So(err, ShouldBeNil)
+		created.Set("foo", "bar")
+		So(created.Save(), ShouldEqual, ErrConflict)
+
+		refreshed, err := mstore.Refresh(ctx, sid, sid+"_new", 10)
+		So(err, ShouldBeNil)
+		refreshed.Set("foo", "baz")
+		So(refreshed.Save(), ShouldBeNil)
+	})
+
+	Convey("Test memory store checks the keys not read after a merged patch", t, func() {
+		mstore := NewMemoryStore()
+		defer mstore.Close()
+
+		ctx := context.Background()
+		sid := "test_memory_store_version_merged"
+		saveMemorySession(mstore, sid, map[string]interface{}{"k1": 0})
+
+		store1, err := mstore.Update(ctx, sid, 10)
+		So(err, ShouldBeNil)
+		store2, err := mstore.Update(ctx, sid, 10)
+		So(err, ShouldBeNil)
+
+		store1.Set("k1", 1)
+		So(store1.Save(), ShouldBeNil)
+		store2.Set("k2", 2)
+		So(store2.Save(), ShouldBeNil)
+
+		// the keys written by the session store itself can be written again
+		store2.Set("k2", 3)
+		So(store2.Save(), ShouldBeNil)
+
+		// k1 was read before store1 wrote it
+		k1, _ := store2.Get("k1")
+		store2.Set("k1", k1.(int)+1)
+		So(store2.Save(), ShouldEqual, ErrConflict)
+
+		store, err := mstore.Update(ctx, sid, 10)
+		So(err, ShouldBeNil)
+		k1, _ = store.Get("k1")
+		So(k1, ShouldEqual, 1)
+		k2, _ := store.Get("k2")
+		So(k2, ShouldEqual, 3)
+	})
+}
+
+func TestMemoryStoreConcurrency(t *testing.T) {
+	Convey("Test memory store with concurrent session stores of a sid", t, func() {
+		mstore := NewMemoryStore()
+		defer mstore.Close()
+
+		ctx := context.Background()
+		sid := "test_memory_store_concurrency"
+		store, err := mstore.Create(ctx, sid, 10)
+		So(err, ShouldBeNil)
+		store.Set("count", 0)
+		So(store.Save(), ShouldBeNil)
+
+		var (
+			wg        sync.WaitGroup
+			mu        sync.Mutex
+			successes int
+		)
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+
+				for j := 0; j < 20; j++ {
+					if _, err := mstore.Check(ctx, sid); err != nil {
+						t.Error(err)
+						return
+					}
+
+					store, err := mstore.Update(ctx, sid, 10)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+
+					count, _ := store.Get("count")
+					store.Set("count", count.(int)+1)
+					store.Set(fmt.Sprintf("key_%d", i), j)
+					err = store.Save()
+					if err == ErrConflict {
+						continue
+					} else if err != nil {
+						t.Error(err)
+						return
+					}
+
+					mu.Lock()
+					successes++
+					mu.Unlock()
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		store, err = mstore.Update(ctx, sid, 10)
+		So(err, ShouldBeNil)
+		count, _ := store.Get("count")
+		So(count, ShouldEqual, successes)
+	})
+
+	Convey("Test memory store with concurrent patches and refreshes", t, func() {
+		mstore := NewMemoryStore()
+		defer mstore.Close()
+
+		ctx := context.Background()
+		pstore := mstore.(PatchStore)
+		sid := "test_memory_store_patch_concurrency"
+
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+
+				key := fmt.Sprintf("key_%d", i)
+				if err := pstore.Patch(ctx, sid, map[string]interface{}{key: i}, nil, 10); err != nil {
+					t.Error(err)
+				}
+				if _, err := mstore.Refresh(ctx, sid+"_missing", sid+"_new", 10); err != nil {
+					t.Error(err)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		store, err := mstore.Update(ctx, sid, 10)
+		So(err, ShouldBeNil)
+		for i := 0; i < 50; i++ {
+			value, ok := store.Get(fmt.Sprintf("key_%d", i))
+			So(ok, ShouldBeTrue)
+			So(value, ShouldEqual, i)
+		}
+
+		store, err = mstore.Refresh(ctx, sid, sid+"_new", 10)
+		So(err, ShouldBeNil)
+		exists, err := mstore.Check(ctx, sid)
+		So(err, ShouldBeNil)
+		So(exists, ShouldBeFalse)
+		value, _ := store.Get("key_0")
+		So(value, ShouldEqual, 0)
+	})
+}
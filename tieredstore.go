@@ -0,0 +1,283 @@
+package session
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+var (
+	_ ManagerStore = &tieredStore{}
+	_ Locker       = &tieredStore{}
+	_ Invalidator  = &localInvalidator{}
+)
+
+// Propagates the invalidations of cached sessions between the instances
+// sharing a backend, e.g. over a message bus
+type Invalidator interface {
+	// Notify the peers that the cached values of a session are stale
+	Publish(ctx context.Context, sid string) error
+	// Call fn for every published invalidation (including the own ones)
+	// until unsubscribe is called
+	Subscribe(fn func(sid string)) (unsubscribe func())
+}
+
+// Define default tiered store options
+var defaultTieredStoreOptions = tieredStoreOptions{
+	ttl:        time.Second * 5,
+	maxEntries: 10000,
+}
+
+type tieredStoreOptions struct {
+	ttl         time.Duration
+	maxEntries  int
+	invalidator Invalidator
+}
+
+type TieredStoreOption func(*tieredStoreOptions)
+
+// Set how long the session values are cached in memory
+func SetTieredStoreTTL(ttl time.Duration) TieredStoreOption {
+	return func(o *tieredStoreOptions) {
+		o.ttl = ttl
+	}
+}
+
+// Set the maximum number of cached sessions
+func SetTieredStoreMaxEntries(maxEntries int) TieredStoreOption {
+	return func(o *tieredStoreOptions) {
+		o.maxEntries = maxEntries
+	}
+}
+
+// Set the channel used to evict stale sessions from the caches of the peer instances
+func SetTieredStoreInvalidator(invalidator Invalidator) TieredStoreOption {
+	return func(o *tieredStoreOptions) {
+		o.invalidator = invalidator
+	}
+}
+
+// Create a new session storage that caches the sessions of remote in memory.
+// Saves are written through to remote, a cached session is used without
+// reading remote until its ttl expires, so the ttl should be short
+func NewTieredStore(remote ManagerStore, opt ...TieredStoreOption) ManagerStore {
+	opts := defaultTieredStoreOptions
+	for _, o := range opt {
+		o(&opts)
+	}
+
+	s := &tieredStore{
+		opts:    &opts,
+		remote:  remote,
+		entries: make(map[string]*tieredEntry),
+	}
+	if opts.invalidator != nil {
+		s.unsubscribe = opts.invalidator.Subscribe(s.evict)
+	}
+	return s
+}
+
+type tieredEntry struct {
+	values    map[string]interface{}
+	expiredAt time.Time
+}
+
+type tieredStore struct {
+	opts        *tieredStoreOptions
+	remote      ManagerStore
+	mu          sync.Mutex
+	entries     map[string]*tieredEntry
+	unsubscribe func()
+}
+
+// Get a copy of the cached values
+func (s *tieredStore) get(sid string) (map[string]interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[sid]
+	if !ok {
+		return nil, false
+	} else if !entry.expiredAt.After(now()) {
+		delete(s.entries, sid)
+		return nil, false
+	}
+	return copyValues(entry.values), true
+}
+
+func (s *tieredStore) set(sid string, values map[string]interface{}) {
+	if s.opts.ttl <= 0 || s.opts.maxEntries <= 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.entries[sid]; !ok && len(s.entries) >= s.opts.maxEntries {
+		s.makeRoom()
+	}
+	s.entries[sid] = &tieredEntry{
+		values:    copyValues(values),
+		expiredAt: now().Add(s.opts.ttl),
+	}
+}
+
+// Remove the expired entries, or an arbitrary entry if none expired, s.mu must be held
+func (s *tieredStore) makeRoom() {
+	t := now()
+	for sid, entry := range s.entries {
+		if !entry.expiredAt.After(t) {
+			delete(s.entries, sid)
+		}
+	}
+
+	if len(s.entries) >= s.opts.maxEntries {
+		for sid := range s.entries {
+			delete(s.entries, sid)
+			break
+		}
+	}
+}
+
+func (s *tieredStore) evict(sid string) {
+	s.mu.Lock()
+	delete(s.entries, sid)
+	s.mu.Unlock()
+}
+
+// Evict the session here and in the peer caches
+func (s *tieredStore) invalidate(ctx context.Context, sid string) error {
+	s.evict(sid)
+	if s.opts.invalidator == nil {
+		return nil
+	}
+	return s.opts.invalidator.Publish(ctx, sid)
+}
+
+// Cache the values of a session store read from remote, the session store
+// returned reads the cache and writes through
+func (s *tieredStore) load(ctx context.Context, rstore Store, expired int64) Store {
+	if isNewStore(rstore) {
+		return newStore(ctx, s, rstore.SessionID(), expired, nil)
+	}
+
+	values, ok := storeValues(rstore)
+	if !ok {
+		return rstore
+	}
+
+	s.set(rstore.SessionID(), values)
+	return newStore(ctx, s, rstore.SessionID(), expired, values)
+}
+
+func (s *tieredStore) save(ctx context.Context, sid string, values map[string]interface{}, expired int64) error {
+	if err := saveValues(ctx, s.remote, sid, values, expired); err != nil {
+		return err
+	}
+
+	if err := s.invalidate(ctx, sid); err != nil {
+		return err
+	}
+	s.set(sid, values)
+	return nil
+}
+
+func (s *tieredStore) Check(ctx context.Context, sid string) (bool, error) {
+	if _, ok := s.get(sid); ok {
+		return true, nil
+	}
+	return s.remote.Check(ctx, sid)
+}
+
+func (s *tieredStore) Create(ctx context.Context, sid string, expired int64) (Store, error) {
+	return newStore(ctx, s, sid, expired, nil), nil
+}
+
+// A cached session does not extend the expiration in remote,
+// it is extended by the next read of remote after the cache ttl
+func (s *tieredStore) Update(ctx context.Context, sid string, expired int64) (Store, error) {
+	if values, ok := s.get(sid); ok {
+		return newStore(ctx, s, sid, expired, values), nil
+	}
+
+	rstore, err := s.remote.Update(ctx, sid, expired)
+	if err != nil {
+		return nil, err
+	}
+	return s.load(ctx, rstore, expired), nil
+}
+
+func (s *tieredStore) Delete(ctx context.Context, sid string) error {
+	if err := s.remote.Delete(ctx, sid); err != nil {
+		return err
+	}
+	return s.invalidate(ctx, sid)
+}
+
+func (s *tieredStore) Refresh(ctx context.Context, oldsid, sid string, expired int64) (Store, error) {
+	rstore, err := s.remote.Refresh(ctx, oldsid, sid, expired)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.invalidate(ctx, oldsid); err != nil {
+		return nil, err
+	}
+	return s.load(ctx, rstore, expired), nil
+}
+
+// The session locks are held in the remote store, so that the lock is shared by all nodes
+func (s *tieredStore) Lock(ctx context.Context, sid string, ttl time.Duration) (string, error) {
+	return wrappedLocker(s.remote).Lock(ctx, sid, ttl)
+}
+
+func (s *tieredStore) Renew(ctx context.Context, sid, token string, ttl time.Duration) error {
+	return wrappedLocker(s.remote).Renew(ctx, sid, token, ttl)
+}
+
+func (s *tieredStore) Unlock(ctx context.Context, sid, token string) error {
+	return wrappedLocker(s.remote).Unlock(ctx, sid, token)
+}
+
+func (s *tieredStore) Close() error {
+	if s.unsubscribe != nil {
+		s.unsubscribe()
+	}
+	return s.remote.Close()
+}
+
+// Create an invalidator delivering the invalidations to the subscribers
+// in the same process, e.g. several tiered stores sharing a backend
+func NewLocalInvalidator() Invalidator {
+	return &localInvalidator{subscribers: make(map[int]func(string))}
+}
+
+type localInvalidator struct {
+	mu          sync.RWMutex
+	next        int
+	subscribers map[int]func(string)
+}
+
+func (i *localInvalidator) Publish(_ context.Context, sid string) error {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	for _, fn := range i.subscribers {
+		fn(sid)
+	}
+	return nil
+}
+
+func (i *localInvalidator) Subscribe(fn func(sid string)) func() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	id := i.next
+	i.next++
+	i.subscribers[id] = fn
+
+	return func() {
+		i.mu.Lock()
+		delete(i.subscribers, id)
+		i.mu.Unlock()
+	}
+}
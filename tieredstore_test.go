@@ -0,0 +1,108 @@
+package session
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// A ManagerStore counting the reads of the wrapped store
+type countingManagerStore struct {
+	ManagerStore
+	checks  int32
+	updates int32
+}
+
+func (s *countingManagerStore) Check(ctx context.Context, sid string) (bool, error) {
+	atomic.AddInt32(&s.checks, 1)
+	return s.ManagerStore.Check(ctx, sid)
+}
+
+func (s *countingManagerStore) Update(ctx context.Context, sid string, expired int64) (Store, error) {
+	atomic.AddInt32(&s.updates, 1)
+	return s.ManagerStore.Update(ctx, sid, expired)
+}
+
+func TestTieredStore(t *testing.T) {
+	Convey("Test tiered store", t, func() {
+		mstore := NewTieredStore(NewMemoryStore())
+		defer mstore.Close()
+		testManagerStore(mstore)
+	})
+
+	Convey("Test tiered store reads the cache", t, func() {
+		remote := &countingManagerStore{ManagerStore: NewMemoryStore()}
+		mstore := NewTieredStore(remote, SetTieredStoreTTL(time.Millisecond*100))
+		defer mstore.Close()
+
+		ctx := context.Background()
+		saveMemorySession(mstore, "a", map[string]interface{}{"foo": "bar"})
+
+		// written through
+		rstore, err := remote.ManagerStore.Update(ctx, "a", 10)
+		So(err, ShouldBeNil)
+		foo, _ := rstore.Get("foo")
+		So(foo, ShouldEqual, "bar")
+
+		for i := 0; i < 3; i++ {
+			exists, err := mstore.Check(ctx, "a")
+			So(err, ShouldBeNil)
+			So(exists, ShouldBeTrue)
+
+			store, err := mstore.Update(ctx, "a", 10)
+			So(err, ShouldBeNil)
+			foo, _ := store.Get("foo")
+			So(foo, ShouldEqual, "bar")
+		}
+		So(atomic.LoadInt32(&remote.checks), ShouldEqual, 0)
+		So(atomic.LoadInt32(&remote.updates), ShouldEqual, 0)
+
+		time.Sleep(time.Millisecond * 150)
+		store, err := mstore.Update(ctx, "a", 10)
+		So(err, ShouldBeNil)
+		foo, _ = store.Get("foo")
+		So(foo, ShouldEqual, "bar")
+		So(atomic.LoadInt32(&remote.updates), ShouldEqual, 1)
+
+		So(mstore.Delete(ctx, "a"), ShouldBeNil)
+		exists, err := mstore.Check(ctx, "a")
+		So(err, ShouldBeNil)
+		So(exists, ShouldBeFalse)
+	})
+
+	Convey("Test tiered store invalidates the peer caches", t, func() {
+		remote := NewMemoryStore()
+		invalidator := NewLocalInvalidator()
+		peer1 := NewTieredStore(remote, SetTieredStoreInvalidator(invalidator))
+		peer2 := NewTieredStore(remote, SetTieredStoreInvalidator(invalidator))
+		defer peer1.Close()
+		defer peer2.Close()
+
+		ctx := context.Background()
+		saveMemorySession(peer1, "a", map[string]interface{}{"foo": "bar"})
+
+		store, err := peer2.Update(ctx, "a", 10)
+		So(err, ShouldBeNil)
+		store.Set("foo", "baz")
+		So(store.Save(), ShouldBeNil)
+
+		store, err = peer1.Update(ctx, "a", 10)
+		So(err, ShouldBeNil)
+		foo, _ := store.Get("foo")
+		So(foo, ShouldEqual, "baz")
+
+		_, err = peer2.Refresh(ctx, "a", "b", 10)
+		So(err, ShouldBeNil)
+		exists, err := peer1.Check(ctx, "a")
+		So(err, ShouldBeNil)
+		So(exists, ShouldBeFalse)
+
+		store, err = peer1.Update(ctx, "b", 10)
+		So(err, ShouldBeNil)
+		foo, _ = store.Get("foo")
+		So(foo, ShouldEqual, "baz")
+	})
+}
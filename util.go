@@ -1,6 +1,7 @@
 package session
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"io"
@@ -26,3 +27,83 @@ func newUUID() string {
 
 	return string(dst)
 }
+
+// serialize the session values with the codec carried by ctx
+// (gob by default) for out-of-process storage
+func encodeValues(ctx context.Context, values map[string]interface{}) ([]byte, error) {
+	codec, ok := FromCodecContext(ctx)
+	if !ok {
+		codec = defaultCodec
+	}
+	return codec.Marshal(values)
+}
+
+// deserialize the session values encoded by encodeValues
+func decodeValues(ctx context.Context, data []byte) (map[string]interface{}, error) {
+	codec, ok := FromCodecContext(ctx)
+	if !ok {
+		codec = defaultCodec
+	}
+	return codec.Unmarshal(data)
+}
+
+// get the values of a session store read from a storage, ok is false if
+// the session store does not support reading all values
+func storeValues(store Store) (map[string]interface{}, bool) {
+	rs, ok := store.(RangeStore)
+	if !ok {
+		return nil, false
+	}
+
+	values := make(map[string]interface{})
+	rs.Range(func(key string, value interface{}) bool {
+		values[key] = value
+		return true
+	})
+	return values, true
+}
+
+// report whether a session store read from a storage was not persisted
+func isNewStore(store Store) bool {
+	ct, ok := store.(ChangeTracker)
+	return ok && ct.IsDirty()
+}
+
+// write all values of a session to a storage, directly for the built-in
+// storages and the SaveStore implementations, through a new session store
+// otherwise. A versioned storage storing the session rejects the new session
+// store with ErrConflict, the values are then written through the stored one
+func saveValues(ctx context.Context, mstore ManagerStore, sid string, values map[string]interface{}, expired int64) error {
+	if saver, ok := mstore.(storeSaver); ok {
+		return saver.save(ctx, sid, values, expired)
+	} else if saver, ok := mstore.(SaveStore); ok {
+		return saver.SaveValues(ctx, sid, values, expired)
+	}
+
+	store, err := mstore.Create(ctx, sid, expired)
+	if err != nil {
+		return err
+	}
+	for key, value := range values {
+		store.Set(key, value)
+	}
+	if err := store.Save(); err != ErrConflict {
+		return err
+	}
+
+	store, err = mstore.Update(ctx, sid, expired)
+	if err != nil {
+		return err
+	}
+	if stored, ok := storeValues(store); ok {
+		for key := range stored {
+			if _, ok := values[key]; !ok {
+				store.Delete(key)
+			}
+		}
+	}
+	for key, value := range values {
+		store.Set(key, value)
+	}
+	return store.Save()
+}
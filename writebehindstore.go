@@ -0,0 +1,375 @@
+package session
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+var (
+	_ WriteBehindStore = &writeBehindStore{}
+	_ Locker           = &writeBehindStore{}
+)
+
+// A session storage writing the sessions to its backend asynchronously
+type WriteBehindStore interface {
+	ManagerStore
+	// Write the pending sessions to the backend
+	Flush() error
+}
+
+// Define default write-behind store options
+var defaultWriteBehindStoreOptions = writeBehindStoreOptions{
+	flushInterval: time.Second,
+	batchSize:     1000,
+}
+
+type writeBehindStoreOptions struct {
+	flushInterval time.Duration
+	batchSize     int
+	errorHandler  func(sid string, err error)
+}
+
+type WriteBehindStoreOption func(*writeBehindStoreOptions)
+
+// Set the interval between two flushes of the pending sessions
+func SetWriteBehindFlushInterval(flushInterval time.Duration) WriteBehindStoreOption {
+	return func(o *writeBehindStoreOptions) {
+		o.flushInterval = flushInterval
+	}
+}
+
+// Set the number of pending sessions that starts a flush before the interval
+func SetWriteBehindBatchSize(batchSize int) WriteBehindStoreOption {
+	return func(o *writeBehindStoreOptions) {
+		o.batchSize = batchSize
+	}
+}
+
+// Set the function called when a pending session fails to be written,
+// the session is written again by the next flush
+func SetWriteBehindErrorHandler(handler func(sid string, err error)) WriteBehindStoreOption {
+	return func(o *writeBehindStoreOptions) {
+		o.errorHandler = handler
+	}
+}
+
+// Create a new session storage that keeps the saved sessions and
+// the expiration updates in memory and writes them to store in batches.
+// A session read from store is kept until the next flush, the updates of
+// its expiration until then are written once. Delete and Refresh are
+// written to store immediately
+func NewWriteBehindStore(store ManagerStore, opt ...WriteBehindStoreOption) WriteBehindStore {
+	opts := defaultWriteBehindStoreOptions
+	for _, o := range opt {
+		o(&opts)
+	}
+	if opts.flushInterval <= 0 {
+		opts.flushInterval = defaultWriteBehindStoreOptions.flushInterval
+	}
+
+	s := &writeBehindStore{
+		opts:    &opts,
+		store:   store,
+		entries: make(map[string]*writeBehindEntry),
+		flushc:  make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	s.wg.Add(1)
+	go s.run()
+	return s
+}
+
+// A session kept in memory, values are never modified once stored
+type writeBehindEntry struct {
+	values  map[string]interface{}
+	expired int64
+	codec   Codec
+	// the values, or only the expiration, must be written
+	dirty   bool
+	touched bool
+	// incremented by each change, to detect the changes made during a flush
+	seq uint64
+}
+
+func (e *writeBehindEntry) pending() bool {
+	return e.dirty || e.touched
+}
+
+type writeBehindStore struct {
+	opts      *writeBehindStoreOptions
+	store     ManagerStore
+	flushMu   sync.Mutex
+	mu        sync.Mutex
+	entries   map[string]*writeBehindEntry
+	seq       uint64
+	npending  int
+	flushc    chan struct{}
+	done      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+func (s *writeBehindStore) run() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.opts.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.flushc:
+		case <-s.done:
+			return
+		}
+		_ = s.Flush()
+	}
+}
+
+// Update the entry of sid, s.mu must be held
+func (s *writeBehindStore) change(ctx context.Context, sid string, fn func(e *writeBehindEntry)) {
+	e, ok := s.entries[sid]
+	if !ok {
+		e = &writeBehindEntry{}
+		s.entries[sid] = e
+	}
+
+	wasPending := e.pending()
+	fn(e)
+	s.seq++
+	e.seq = s.seq
+	if codec, ok := FromCodecContext(ctx); ok {
+		e.codec = codec
+	}
+
+	if !wasPending && e.pending() {
+		s.npending++
+		if s.opts.batchSize > 0 && s.npending >= s.opts.batchSize {
+			select {
+			case s.flushc <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// Get a copy of the values kept for sid, extending its expiration
+func (s *writeBehindStore) get(ctx context.Context, sid string, expired int64) (map[string]interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[sid]
+	if !ok {
+		return nil, false
+	}
+
+	values := copyValues(e.values)
+	s.change(ctx, sid, func(e *writeBehindEntry) {
+		e.expired = expired
+		e.touched = true
+	})
+	return values, true
+}
+
+// Remove the entry of sid, s.mu must be held
+func (s *writeBehindStore) remove(sid string) {
+	if e, ok := s.entries[sid]; ok {
+		if e.pending() {
+			s.npending--
+		}
+		delete(s.entries, sid)
+	}
+}
+
+func (s *writeBehindStore) save(ctx context.Context, sid string, values map[string]interface{}, expired int64) error {
+	values = copyValues(values)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.change(ctx, sid, func(e *writeBehindEntry) {
+		e.values = values
+		e.expired = expired
+		e.dirty = true
+	})
+	return nil
+}
+
+type writeBehindWrite struct {
+	sid   string
+	entry writeBehindEntry
+	err   error
+}
+
+// Write the pending sessions to the backend, the sessions that were only
+// read are forgotten. It returns the first error, the sessions that
+// failed are written again by the next flush
+func (s *writeBehindStore) Flush() error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	var writes []*writeBehindWrite
+	s.mu.Lock()
+	for sid, e := range s.entries {
+		if e.pending() {
+			writes = append(writes, &writeBehindWrite{sid: sid, entry: *e})
+		} else {
+			delete(s.entries, sid)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, w := range writes {
+		ctx := context.Background()
+		if w.entry.codec != nil {
+			ctx = newCodecContext(ctx, w.entry.codec)
+		}
+
+		if w.entry.dirty {
+			w.err = saveValues(ctx, s.store, w.sid, w.entry.values, w.entry.expired)
+		} else {
+			_, w.err = s.store.Update(ctx, w.sid, w.entry.expired)
+		}
+	}
+
+	var err error
+	s.mu.Lock()
+	for _, w := range writes {
+		// keep the entries changed during the flush, they are still pending
+		if e, ok := s.entries[w.sid]; ok && w.err == nil && e.seq == w.entry.seq {
+			s.remove(w.sid)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, w := range writes {
+		if w.err == nil {
+			continue
+		} else if err == nil {
+			err = w.err
+		}
+		if s.opts.errorHandler != nil {
+			s.opts.errorHandler(w.sid, w.err)
+		}
+	}
+	return err
+}
+
+// Keep the values of a session store read from the backend, the session
+// store returned saves them asynchronously
+func (s *writeBehindStore) load(ctx context.Context, rstore Store, expired int64) Store {
+	sid := rstore.SessionID()
+	if isNewStore(rstore) {
+		return newStore(ctx, s, sid, expired, nil)
+	}
+
+	values, ok := storeValues(rstore)
+	if !ok {
+		return rstore
+	}
+
+	s.mu.Lock()
+	if _, ok := s.entries[sid]; !ok {
+		s.entries[sid] = &writeBehindEntry{values: copyValues(values), expired: expired}
+	}
+	s.mu.Unlock()
+	return newStore(ctx, s, sid, expired, values)
+}
+
+func (s *writeBehindStore) Check(ctx context.Context, sid string) (bool, error) {
+	s.mu.Lock()
+	_, ok := s.entries[sid]
+	s.mu.Unlock()
+
+	if ok {
+		return true, nil
+	}
+	return s.store.Check(ctx, sid)
+}
+
+func (s *writeBehindStore) Create(ctx context.Context, sid string, expired int64) (Store, error) {
+	return newStore(ctx, s, sid, expired, nil), nil
+}
+
+func (s *writeBehindStore) Update(ctx context.Context, sid string, expired int64) (Store, error) {
+	if values, ok := s.get(ctx, sid, expired); ok {
+		return newStore(ctx, s, sid, expired, values), nil
+	}
+
+	rstore, err := s.store.Update(ctx, sid, expired)
+	if err != nil {
+		return nil, err
+	}
+	return s.load(ctx, rstore, expired), nil
+}
+
+// The session is deleted from the backend immediately,
+// waiting for a running flush so that it does not write it again
+func (s *writeBehindStore) Delete(ctx context.Context, sid string) error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.mu.Lock()
+	s.remove(sid)
+	s.mu.Unlock()
+	return s.store.Delete(ctx, sid)
+}
+
+// The pending values of oldsid are written before it is refreshed in the backend
+func (s *writeBehindStore) Refresh(ctx context.Context, oldsid, sid string, expired int64) (Store, error) {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.mu.Lock()
+	var pending writeBehindEntry
+	if e, ok := s.entries[oldsid]; ok {
+		pending = *e
+	}
+	s.mu.Unlock()
+
+	if pending.dirty {
+		if err := saveValues(ctx, s.store, oldsid, pending.values, pending.expired); err != nil {
+			return nil, err
+		}
+	}
+
+	rstore, err := s.store.Refresh(ctx, oldsid, sid, expired)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.remove(oldsid)
+	s.remove(sid)
+	s.mu.Unlock()
+	return s.load(ctx, rstore, expired), nil
+}
+
+// Close stops the background flushes, writes the pending sessions and closes the backend
+// The session locks are held in the wrapped store
+func (s *writeBehindStore) Lock(ctx context.Context, sid string, ttl time.Duration) (string, error) {
+	return wrappedLocker(s.store).Lock(ctx, sid, ttl)
+}
+
+func (s *writeBehindStore) Renew(ctx context.Context, sid, token string, ttl time.Duration) error {
+	return wrappedLocker(s.store).Renew(ctx, sid, token, ttl)
+}
+
+func (s *writeBehindStore) Unlock(ctx context.Context, sid, token string) error {
+	return wrappedLocker(s.store).Unlock(ctx, sid, token)
+}
+
+func (s *writeBehindStore) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.wg.Wait()
+
+		err = s.Flush()
+		if cerr := s.store.Close(); err == nil {
+			err = cerr
+		}
+	})
+	return err
+}
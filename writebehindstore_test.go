@@ -0,0 +1,119 @@
+package session
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWriteBehindStore(t *testing.T) {
+	Convey("Test write-behind store", t, func() {
+		mstore := NewWriteBehindStore(NewMemoryStore())
+		defer mstore.Close()
+		testManagerStore(mstore)
+	})
+
+	Convey("Test write-behind store with a zero flush interval", t, func() {
+		mstore := NewWriteBehindStore(NewMemoryStore(), SetWriteBehindFlushInterval(0))
+		So(mstore.Close(), ShouldBeNil)
+	})
+
+	Convey("Test write-behind store coalesces the writes", t, func() {
+		remote := &countingManagerStore{ManagerStore: NewMemoryStore()}
+		mstore := NewWriteBehindStore(remote, SetWriteBehindFlushInterval(time.Hour))
+		defer mstore.Close()
+
+		ctx := context.Background()
+		saveMemorySession(mstore, "a", map[string]interface{}{"foo": "bar"})
+		exists, err := remote.ManagerStore.Check(ctx, "a")
+		So(err, ShouldBeNil)
+		So(exists, ShouldBeFalse)
+
+		// read your writes
+		for i := 0; i < 3; i++ {
+			exists, err := mstore.Check(ctx, "a")
+			So(err, ShouldBeNil)
+			So(exists, ShouldBeTrue)
+
+			store, err := mstore.Update(ctx, "a", 10)
+			So(err, ShouldBeNil)
+			foo, _ := store.Get("foo")
+			So(foo, ShouldEqual, "bar")
+		}
+		So(atomic.LoadInt32(&remote.updates), ShouldEqual, 0)
+
+		So(mstore.Flush(), ShouldBeNil)
+		exists, err = remote.ManagerStore.Check(ctx, "a")
+		So(err, ShouldBeNil)
+		So(exists, ShouldBeTrue)
+
+		// read once, the expiration updates are written once by the flush
+		for i := 0; i < 3; i++ {
+			store, err := mstore.Update(ctx, "a", 10)
+			So(err, ShouldBeNil)
+			foo, _ := store.Get("foo")
+			So(foo, ShouldEqual, "bar")
+		}
+		So(atomic.LoadInt32(&remote.updates), ShouldEqual, 1)
+		So(mstore.Flush(), ShouldBeNil)
+		So(atomic.LoadInt32(&remote.updates), ShouldEqual, 2)
+
+		saveMemorySession(mstore, "b", map[string]interface{}{"foo": "bar"})
+		So(mstore.Delete(ctx, "b"), ShouldBeNil)
+		So(mstore.Flush(), ShouldBeNil)
+		exists, err = mstore.Check(ctx, "b")
+		So(err, ShouldBeNil)
+		So(exists, ShouldBeFalse)
+	})
+
+	Convey("Test write-behind store flushes full batches", t, func() {
+		remote := NewMemoryStore()
+		mstore := NewWriteBehindStore(remote,
+			SetWriteBehindFlushInterval(time.Hour),
+			SetWriteBehindBatchSize(2),
+		)
+		defer mstore.Close()
+
+		ctx := context.Background()
+		saveMemorySession(mstore, "a", map[string]interface{}{"foo": "bar"})
+		saveMemorySession(mstore, "b", map[string]interface{}{"foo": "bar"})
+
+		var exists bool
+		for i := 0; i < 50 && !exists; i++ {
+			time.Sleep(time.Millisecond * 10)
+			exists, _ = remote.Check(ctx, "b")
+		}
+		So(exists, ShouldBeTrue)
+		exists, _ = remote.Check(ctx, "a")
+		So(exists, ShouldBeTrue)
+	})
+
+	Convey("Test write-behind store keeps the failed writes", t, func() {
+		inner := NewMemoryStore()
+		remote := &failingManagerStore{ManagerStore: inner}
+		var failed []string
+		mstore := NewWriteBehindStore(remote,
+			SetWriteBehindFlushInterval(time.Hour),
+			SetWriteBehindErrorHandler(func(sid string, err error) {
+				failed = append(failed, sid)
+			}),
+		)
+
+		ctx := context.Background()
+		saveMemorySession(mstore, "a", map[string]interface{}{"foo": "bar"})
+		remote.setFail(true)
+		So(mstore.Flush(), ShouldEqual, errTestStore)
+		So(failed, ShouldResemble, []string{"a"})
+
+		// written by the flush on close
+		remote.setFail(false)
+		So(mstore.Close(), ShouldBeNil)
+		store, err := inner.Update(ctx, "a", 10)
+		So(err, ShouldBeNil)
+		foo, _ := store.Get("foo")
+		So(foo, ShouldEqual, "bar")
+	})
+}